@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Outcomes of an exchange as recorded in the log and the journal.
+const (
+	outcomeCompleted     = "completed"
+	outcomeClientAborted = "client aborted"
+	outcomeWriteFailed   = "write failed"
+	outcomeServerTimeout = "server timeout"
+)
+
+// requestOutcome tells how an exchange ended: the client went away (the
+// request context was canceled or the connection broke while writing), the
+// server gave up after REQUEST_TIMEOUT, or the response could not be written.
+func requestOutcome(ctx context.Context, writeErr error) (string, error) {
+	switch err := ctx.Err(); {
+	case errors.Is(err, context.DeadlineExceeded):
+		return outcomeServerTimeout, err
+	case err != nil:
+		return outcomeClientAborted, err
+	case writeErr == nil:
+		return outcomeCompleted, nil
+	case errors.Is(writeErr, syscall.EPIPE), errors.Is(writeErr, syscall.ECONNRESET), errors.Is(writeErr, net.ErrClosed):
+		return outcomeClientAborted, writeErr
+	}
+	return outcomeWriteFailed, writeErr
+}
+
+// timeoutMiddleware gives each request REQUEST_TIMEOUT to complete. Delays
+// and hanging mocks stop waiting when it passes and answer 503.
+func timeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// wait sleeps for d unless the request ends first; it reports whether the
+// full delay passed.
+func wait(r *http.Request, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// abandon answers a request whose wait was cut short: nothing is written to
+// a client that went away, a server timeout gets a 503.
+func abandon(w http.ResponseWriter, r *http.Request) {
+	if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		writeError(w, r, http.StatusServiceUnavailable, "Service Unavailable", "The request did not complete within REQUEST_TIMEOUT")
+	}
+}
+
+// hangHandler never answers on its own: it holds the request open until the
+// client gives up or REQUEST_TIMEOUT passes, for testing client timeouts.
+// With ?headers=true the status line and headers are sent first, so only the
+// body read hangs.
+func hangHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("headers") == "true" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "Internal Server Error", fmt.Sprintf("Cannot flush headers: %v", err))
+			return
+		}
+		<-r.Context().Done()
+		return
+	}
+	<-r.Context().Done()
+	abandon(w, r)
+}
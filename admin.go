@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// adminPrefix is the path under which the control API is mounted on the admin listener.
+const adminPrefix = "/__admin"
+
+// adminAPI is the control API used by test suites and the CLI.
+type adminAPI struct {
+	state       *stateRegistry
+	namespaces  *namespaceManager
+	health      *healthState
+	snapshotDir string
+	config      adminConfig
+	audit       *auditLog
+	logs        *logPipeline
+	cache       *responseCache
+
+	changeMu sync.Mutex // serializes audited changes
+}
+
+func (a *adminAPI) register(r *mux.Router) {
+	admin := r.PathPrefix(adminPrefix).Subrouter()
+
+	admin.HandleFunc("/snapshot", a.getSnapshot).Methods("GET")
+	admin.HandleFunc("/snapshot", a.saveSnapshot).Methods("POST")
+	admin.HandleFunc("/restore", a.restoreSnapshot).Methods("POST")
+
+	admin.HandleFunc("/health", a.getHealth).Methods("GET")
+	admin.HandleFunc("/health", a.setHealth).Methods("PUT")
+	admin.HandleFunc("/health", a.clearHealth).Methods("DELETE")
+
+	admin.HandleFunc("/namespaces", a.listNamespaces).Methods("GET")
+	admin.HandleFunc("/namespaces/{name}", a.deleteNamespace).Methods("DELETE")
+
+	// The endpoints below act on the namespace selected by the session header or /__ns/ prefix
+	admin.HandleFunc("/requests", a.listRequests).Methods("GET")
+	admin.HandleFunc("/requests", a.clearRequests).Methods("DELETE")
+	admin.HandleFunc("/requests/{id}/raw", a.rawRequest).Methods("GET")
+	admin.HandleFunc("/verify", a.verify).Methods("POST")
+	admin.HandleFunc("/export", a.export).Methods("GET")
+
+	admin.HandleFunc("/mocks", a.listMocks).Methods("GET")
+	admin.HandleFunc("/mocks", a.addMock).Methods("POST")
+	admin.HandleFunc("/mocks", a.clearMocks).Methods("DELETE")
+	admin.HandleFunc("/mocks/{id}", a.deleteMock).Methods("DELETE")
+
+	admin.HandleFunc("/reset", a.reset).Methods("POST")
+
+	admin.HandleFunc("/audit", a.listAudit).Methods("GET")
+
+	admin.HandleFunc("/cache", a.cacheStats).Methods("GET")
+	admin.HandleFunc("/cache", a.clearCache).Methods("DELETE")
+
+	admin.HandleFunc("/clock", a.getClock).Methods("GET")
+	admin.HandleFunc("/clock", a.setClock).Methods("PUT")
+	admin.HandleFunc("/clock", a.resetClock).Methods("DELETE")
+
+	a.registerDiagnostics(admin)
+}
+
+// getSnapshot returns the full mock state.
+func (a *adminAPI) getSnapshot(w http.ResponseWriter, r *http.Request) {
+	snap, err := a.state.snapshot()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Internal Server Error", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, snap)
+}
+
+// saveSnapshot writes the full state to ?file=NAME inside the snapshot directory.
+func (a *adminAPI) saveSnapshot(w http.ResponseWriter, r *http.Request) {
+	path, err := snapshotPath(a.snapshotDir, r.URL.Query().Get("file"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Bad Request", err.Error())
+		return
+	}
+	if err := os.MkdirAll(a.snapshotDir, 0o755); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Internal Server Error", err.Error())
+		return
+	}
+	if err := a.state.saveFile(path); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Internal Server Error", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"file": path, "state": a.state.names()})
+}
+
+// restoreSnapshot restores a snapshot posted as the body, or ?file=NAME from
+// the snapshot directory, and makes it what /reset returns to.
+func (a *adminAPI) restoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	var snap *snapshot
+	if name := r.URL.Query().Get("file"); name != "" {
+		path, err := snapshotPath(a.snapshotDir, name)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Bad Request", err.Error())
+			return
+		}
+		if snap, err = readSnapshotFile(path); err != nil {
+			writeError(w, r, http.StatusNotFound, "Not Found", err.Error())
+			return
+		}
+	} else {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Bad Request", "Error reading request body")
+			return
+		}
+		snap = &snapshot{}
+		if err := json.Unmarshal(body, snap); err != nil {
+			writeError(w, r, http.StatusBadRequest, "Bad Request", "Request body must be a snapshot document")
+			return
+		}
+	}
+	before := a.namespaces.summary()
+	if err := a.state.restore(snap); err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "Unprocessable Entity", err.Error())
+		return
+	}
+	a.namespaces.rebase()
+	recordChange(r, before, a.namespaces.summary())
+	writeJSON(w, http.StatusOK, map[string]interface{}{"restored": true, "created_at": snap.CreatedAt})
+}
+
+// getHealth returns the forced health state next to the real readiness checks.
+func (a *adminAPI) getHealth(w http.ResponseWriter, r *http.Request) {
+	state, checks := a.health.readiness()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"override": a.health.override(),
+		"ready":    state,
+		"checks":   checks,
+	})
+}
+
+// setHealth forces liveness/readiness states and an optional delay, e.g.
+// {"ready": "unhealthy"} or {"live": "degraded", "delay_ms": 3000}.
+func (a *adminAPI) setHealth(w http.ResponseWriter, r *http.Request) {
+	var o healthOverride
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Bad Request", "Request body must be a health override object")
+		return
+	}
+	previous, err := a.health.setOverride(o)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Bad Request", err.Error())
+		return
+	}
+	recordChange(r, previous, o)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"override": o})
+}
+
+func (a *adminAPI) clearHealth(w http.ResponseWriter, r *http.Request) {
+	previous, _ := a.health.setOverride(healthOverride{})
+	recordChange(r, previous, healthOverride{})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminAPI) listNamespaces(w http.ResponseWriter, r *http.Request) {
+	out := make([]map[string]interface{}, 0)
+	for _, ns := range a.namespaces.all() {
+		info := map[string]interface{}{
+			"name":      ns.name,
+			"created":   ns.created.Format(time.RFC3339),
+			"last_used": ns.idleSince().Format(time.RFC3339),
+			"requests":  ns.journal.len(),
+		}
+		if ns.store != nil {
+			counts := make(map[string]int)
+			for _, name := range ns.store.collectionNames() {
+				records, _ := ns.store.list(name)
+				counts[name] = len(records)
+			}
+			info["records"] = counts
+		}
+		out = append(out, info)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (a *adminAPI) deleteNamespace(w http.ResponseWriter, r *http.Request) {
+	ns := a.namespaces.remove(mux.Vars(r)["name"])
+	if ns == nil {
+		writeError(w, r, http.StatusNotFound, "Not Found", "No such namespace")
+		return
+	}
+	recordChange(r, ns.summary(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listRequests returns captured requests, optionally filtered by
+// ?method=&path=&status=&contains=&since_id= and capped to the newest ?limit=.
+func (a *adminAPI) listRequests(w http.ResponseWriter, r *http.Request) {
+	filter, err := journalFilterFromQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Bad Request", "Invalid filter: "+err.Error())
+		return
+	}
+	entries := namespaceFrom(r).journal.search(filter)
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err := strconv.Atoi(v); err == nil && limit >= 0 && limit < len(entries) {
+			entries = entries[len(entries)-limit:]
+		}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// rawRequest returns a captured request exactly as it was received.
+func (a *adminAPI) rawRequest(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Bad Request", "Request id must be a number")
+		return
+	}
+	for _, e := range namespaceFrom(r).journal.list() {
+		if e.ID != id {
+			continue
+		}
+		if len(e.RawRequest) == 0 {
+			writeError(w, r, http.StatusNotFound, "Not Found", fmt.Sprintf("Request %d has no raw capture; start the server with RAW_CAPTURE=true", id))
+			return
+		}
+		data := []byte(e.RawRequest)
+		if e.RawRequestBase64 {
+			data, _ = base64.StdEncoding.DecodeString(string(e.RawRequest))
+		}
+		if e.RawRequestTruncated {
+			w.Header().Set("X-Raw-Truncated", "true")
+		}
+		w.Header().Set("Content-Type", "message/http")
+		w.Write(data)
+		return
+	}
+	writeError(w, r, http.StatusNotFound, "Not Found", fmt.Sprintf("Request %d is not in the journal", id))
+}
+
+// verifyRequest asserts how many captured requests match a filter. Without
+// count, at_least or at_most it expects at least one match.
+type verifyRequest struct {
+	journalFilter
+	Count   *int `json:"count,omitempty"`
+	AtLeast *int `json:"at_least,omitempty"`
+	AtMost  *int `json:"at_most,omitempty"`
+}
+
+func (a *adminAPI) verify(w http.ResponseWriter, r *http.Request) {
+	var req verifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Bad Request", "Request body must be a verification object")
+		return
+	}
+	matched := len(namespaceFrom(r).journal.search(req.journalFilter))
+	ok := true
+	var expected []string
+	if req.Count != nil {
+		ok = ok && matched == *req.Count
+		expected = append(expected, fmt.Sprintf("exactly %d", *req.Count))
+	}
+	if req.AtLeast != nil {
+		ok = ok && matched >= *req.AtLeast
+		expected = append(expected, fmt.Sprintf("at least %d", *req.AtLeast))
+	}
+	if req.AtMost != nil {
+		ok = ok && matched <= *req.AtMost
+		expected = append(expected, fmt.Sprintf("at most %d", *req.AtMost))
+	}
+	if len(expected) == 0 {
+		ok = matched >= 1
+		expected = append(expected, "at least 1")
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ok":       ok,
+		"matched":  matched,
+		"expected": strings.Join(expected, " and "),
+	})
+}
+
+// export turns the captured requests into runtime mock definitions that can be added back with POST /mocks.
+func (a *adminAPI) export(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, mocksFromJournal(namespaceFrom(r).journal.list()))
+}
+
+func (a *adminAPI) listMocks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, namespaceFrom(r).mocks.list())
+}
+
+// addMock accepts a single mock definition or an array of them.
+func (a *adminAPI) addMock(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Bad Request", "Error reading request body")
+		return
+	}
+	var defs []mockDef
+	if err := json.Unmarshal(body, &defs); err != nil {
+		var def mockDef
+		if err := json.Unmarshal(body, &def); err != nil {
+			writeError(w, r, http.StatusBadRequest, "Bad Request", "Request body must be a mock definition or an array of them")
+			return
+		}
+		defs = []mockDef{def}
+	}
+	mocks := namespaceFrom(r).mocks
+	before := mocks.list()
+	added, err := mocks.addAll(defs)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Bad Request", err.Error())
+		return
+	}
+	recordChange(r, before, mocks.list())
+	writeJSON(w, http.StatusCreated, added)
+}
+
+func (a *adminAPI) deleteMock(w http.ResponseWriter, r *http.Request) {
+	mocks := namespaceFrom(r).mocks
+	before := mocks.list()
+	if !mocks.remove(mux.Vars(r)["id"]) {
+		writeError(w, r, http.StatusNotFound, "Not Found", "No such mock")
+		return
+	}
+	recordChange(r, before, mocks.list())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminAPI) clearMocks(w http.ResponseWriter, r *http.Request) {
+	mocks := namespaceFrom(r).mocks
+	before := mocks.list()
+	mocks.clear()
+	recordChange(r, before, mocks.list())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reset returns the namespace to its startup baseline and restarts the
+// numbering of its requests and mocks.
+func (a *adminAPI) reset(w http.ResponseWriter, r *http.Request) {
+	before, after := a.namespaces.reset(namespaceFrom(r))
+	recordChange(r, before, after)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"reset": true, "namespace": namespaceFrom(r).name})
+}
+
+func (a *adminAPI) clearRequests(w http.ResponseWriter, r *http.Request) {
+	dropped := namespaceFrom(r).journal.clear()
+	recordChange(r, map[string]int{"requests": dropped}, map[string]int{"requests": 0})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cacheStats reports the response file cache's size and hit/miss counters.
+func (a *adminAPI) cacheStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.cache.stats())
+}
+
+func (a *adminAPI) clearCache(w http.ResponseWriter, r *http.Request) {
+	a.cache.clear()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listAudit returns recorded admin changes, capped to the newest ?limit=.
+func (a *adminAPI) listAudit(w http.ResponseWriter, r *http.Request) {
+	entries := a.audit.list()
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err := strconv.Atoi(v); err == nil && limit >= 0 && limit < len(entries) {
+			entries = entries[len(entries)-limit:]
+		}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// snapshotPath keeps snapshot files inside dir so the API cannot write elsewhere.
+func snapshotPath(dir, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("query parameter file is required")
+	}
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("file must be a plain file name inside the snapshot directory")
+	}
+	if filepath.Ext(name) == "" {
+		name += ".json"
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// clockChange is the body of PUT /__admin/clock. Time is applied before
+// Advance; Frozen stops or restarts the clock.
+type clockChange struct {
+	Time    string `json:"time,omitempty"`
+	Advance string `json:"advance,omitempty"`
+	Frozen  *bool  `json:"frozen,omitempty"`
+}
+
+func (a *adminAPI) getClock(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, clock.state())
+}
+
+func (a *adminAPI) setClock(w http.ResponseWriter, r *http.Request) {
+	var c clockChange
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Bad Request", "Request body must be a clock object")
+		return
+	}
+	var at time.Time
+	var step time.Duration
+	var err error
+	if c.Time != "" {
+		if at, err = time.Parse(time.RFC3339, c.Time); err != nil {
+			writeError(w, r, http.StatusBadRequest, "Bad Request", fmt.Sprintf("Invalid time: %v", err))
+			return
+		}
+	}
+	if c.Advance != "" {
+		if step, err = time.ParseDuration(c.Advance); err != nil {
+			writeError(w, r, http.StatusBadRequest, "Bad Request", fmt.Sprintf("Invalid advance: %v", err))
+			return
+		}
+	}
+	before := clock.setting()
+	if c.Frozen != nil {
+		clock.freeze(*c.Frozen)
+	}
+	if c.Time != "" {
+		clock.set(at)
+	}
+	clock.advance(step)
+	recordChange(r, before, clock.setting())
+	writeJSON(w, http.StatusOK, clock.state())
+}
+
+// resetClock returns the clock to its startup state.
+func (a *adminAPI) resetClock(w http.ResponseWriter, r *http.Request) {
+	before := clock.setting()
+	clock.reset()
+	recordChange(r, before, clock.setting())
+	writeJSON(w, http.StatusOK, clock.state())
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// adminConfig describes the separate listener the admin API is served on, so
+// the client under test cannot reach it and the main CORS policy never applies.
+type adminConfig struct {
+	addr     string            // ADMIN_ADDR, TCP address; ignored when socket is set
+	socket   string            // ADMIN_SOCKET, unix socket path
+	tokens   map[string]string // token -> caller name, from ADMIN_TOKEN and ADMIN_TOKENS
+	certFile string            // ADMIN_TLS_CERT
+	keyFile  string            // ADMIN_TLS_KEY
+	clientCA string            // ADMIN_TLS_CLIENT_CA, requires client certificates (mTLS)
+	readOnly bool              // ADMIN_READ_ONLY
+	auditLog string            // AUDIT_LOG, JSON-lines file of admin changes
+	auditMax int               // AUDIT_SIZE, changes kept in memory
+}
+
+func adminConfigFromEnv() (adminConfig, error) {
+	c := adminConfig{
+		addr:     os.Getenv("ADMIN_ADDR"),
+		socket:   os.Getenv("ADMIN_SOCKET"),
+		tokens:   make(map[string]string),
+		certFile: os.Getenv("ADMIN_TLS_CERT"),
+		keyFile:  os.Getenv("ADMIN_TLS_KEY"),
+		clientCA: os.Getenv("ADMIN_TLS_CLIENT_CA"),
+		readOnly: os.Getenv("ADMIN_READ_ONLY") == "true",
+		auditLog: os.Getenv("AUDIT_LOG"),
+		auditMax: 500,
+	}
+	if c.addr == "" {
+		c.addr = "127.0.0.1:9090"
+	}
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		c.tokens[token] = "admin"
+	}
+	// ADMIN_TOKENS="ci:secret1,alice:secret2" names each caller in the audit log
+	if list := os.Getenv("ADMIN_TOKENS"); list != "" {
+		for _, item := range strings.Split(list, ",") {
+			name, token, ok := strings.Cut(strings.TrimSpace(item), ":")
+			if !ok || name == "" || token == "" {
+				return c, fmt.Errorf("ADMIN_TOKENS entries must look like name:token, got %q", item)
+			}
+			c.tokens[token] = name
+		}
+	}
+	if (c.certFile == "") != (c.keyFile == "") {
+		return c, fmt.Errorf("ADMIN_TLS_CERT and ADMIN_TLS_KEY must be set together")
+	}
+	if c.clientCA != "" && c.certFile == "" {
+		return c, fmt.Errorf("ADMIN_TLS_CLIENT_CA requires ADMIN_TLS_CERT and ADMIN_TLS_KEY")
+	}
+	if v := os.Getenv("AUDIT_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c, fmt.Errorf("invalid AUDIT_SIZE %q", v)
+		}
+		c.auditMax = n
+	}
+	return c, nil
+}
+
+// describe summarizes where and how the admin API is reachable for the startup log.
+func (c adminConfig) describe() string {
+	where := "http://" + c.addr
+	if c.certFile != "" {
+		where = "https://" + c.addr
+	}
+	if c.socket != "" {
+		where = "unix:" + c.socket
+	}
+	var auth []string
+	if len(c.tokens) > 0 {
+		auth = append(auth, fmt.Sprintf("%d token(s)", len(c.tokens)))
+	}
+	if c.clientCA != "" {
+		auth = append(auth, "client certificates")
+	}
+	if len(auth) == 0 {
+		auth = append(auth, "no authentication")
+	}
+	if c.readOnly {
+		auth = append(auth, "read-only")
+	}
+	return fmt.Sprintf("%s (%s)", where, strings.Join(auth, ", "))
+}
+
+// exposedWithoutAuth reports whether the admin API listens beyond loopback without any authentication.
+func (c adminConfig) exposedWithoutAuth() bool {
+	if len(c.tokens) > 0 || c.clientCA != "" || c.socket != "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(c.addr)
+	if err != nil {
+		return true
+	}
+	if host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip == nil || !ip.IsLoopback()
+}
+
+func (c adminConfig) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if c.clientCA != "" {
+		pem, err := os.ReadFile(c.clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.clientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// serveAdmin serves the admin API until the listener fails.
+func serveAdmin(c adminConfig, handler http.Handler) error {
+	var ln net.Listener
+	var err error
+	if c.socket != "" {
+		os.Remove(c.socket)
+		ln, err = net.Listen("unix", c.socket)
+	} else {
+		ln, err = net.Listen("tcp", c.addr)
+	}
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	if c.certFile != "" {
+		if srv.TLSConfig, err = c.tlsConfig(); err != nil {
+			return err
+		}
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
+}
+
+type adminCallerKey struct{}
+
+// handler returns the admin API with authentication, read-only enforcement
+// and auditing. The namespace is resolved the same way as on the main port.
+func (a *adminAPI) handler() http.Handler {
+	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, "Not Found", "No such admin endpoint")
+	})
+	a.register(r)
+	return a.namespaces.middleware(a.authenticate(a.audited(r)))
+}
+
+// authenticate identifies the caller by bearer token (Authorization or
+// X-Admin-Token) and/or verified client certificate.
+func (a *adminAPI) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller := "anonymous"
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			caller = "cert:" + r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		if len(a.config.tokens) > 0 {
+			name, ok := a.config.tokenName(requestToken(r))
+			if !ok {
+				log.Printf("Rejected unauthenticated admin request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				writeError(w, r, http.StatusUnauthorized, "Unauthorized", "A valid admin token is required")
+				return
+			}
+			caller = name
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminCallerKey{}, caller)))
+	})
+}
+
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return r.Header.Get("X-Admin-Token")
+}
+
+// tokenName compares against every configured token in constant time.
+func (c adminConfig) tokenName(token string) (string, bool) {
+	name, found := "", false
+	for t, n := range c.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			name, found = n, true
+		}
+	}
+	return name, found
+}
+
+// isChange reports whether an admin call modifies state. POST /verify only reads.
+func isChange(r *http.Request) bool {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	return r.URL.Path != adminPrefix+"/verify"
+}
+
+// audited rejects changes in read-only mode and records every other change
+// with the state its handler reported as changed. Changes are serialized, so
+// the parts only the admin API changes (mocks, health, clock) cannot move
+// between a handler's before and after.
+func (a *adminAPI) audited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isChange(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if a.config.readOnly {
+			writeError(w, r, http.StatusForbidden, "Forbidden", "The admin API is read-only")
+			return
+		}
+		start := clock.Now()
+		change := &auditChange{}
+		rw := &responseWriterWrapper{ResponseWriter: w, statusCode: http.StatusOK}
+		a.changeMu.Lock()
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), auditChangeKey{}, change)))
+		a.changeMu.Unlock()
+		entry := auditEntry{
+			Time:       start.Format(time.RFC3339Nano),
+			Who:        r.Context().Value(adminCallerKey{}).(string),
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.RawQuery,
+			Namespace:  namespaceFrom(r).name,
+			Status:     rw.statusCode,
+		}
+		entry.Before, entry.After, _ = diffJSON(decodedJSON(change.before), decodedJSON(change.after))
+		a.audit.add(entry)
+	})
+}
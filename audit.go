@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+)
+
+// auditEntry records one change made through the admin API: who made it, what
+// was called and the parts of the mock state that differ before and after.
+type auditEntry struct {
+	ID         int64       `json:"id"`
+	Time       string      `json:"time"`
+	Who        string      `json:"who"`
+	RemoteAddr string      `json:"remote_addr"`
+	Method     string      `json:"method"`
+	Path       string      `json:"path"`
+	Query      string      `json:"query,omitempty"`
+	Namespace  string      `json:"namespace,omitempty"`
+	Status     int         `json:"status"`
+	Before     interface{} `json:"before,omitempty"`
+	After      interface{} `json:"after,omitempty"`
+}
+
+// auditLog keeps the most recent admin changes in memory and appends every
+// change to an optional JSON-lines file.
+type auditLog struct {
+	mu      sync.Mutex
+	entries []auditEntry
+	size    int
+	file    *os.File
+	seq     int64 // id of the last entry; never reset, so ids stay unique
+}
+
+// newAuditLog keeps size entries in memory; path may be empty.
+func newAuditLog(size int, path string) (*auditLog, error) {
+	l := &auditLog{size: size}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		l.file = f
+	}
+	return l, nil
+}
+
+func (l *auditLog) add(e auditEntry) {
+	log.Printf("AUDIT: %s %s %s by %s -> %d", e.Method, e.Path, e.Namespace, e.Who, e.Status)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	e.ID = l.seq
+	l.entries = append(l.entries, e)
+	if len(l.entries) > l.size {
+		l.entries = l.entries[len(l.entries)-l.size:]
+	}
+	if l.file != nil {
+		line, err := json.Marshal(e)
+		if err == nil {
+			_, err = l.file.Write(append(line, '\n'))
+		}
+		if err != nil {
+			log.Printf("Failed to write audit log: %v", err)
+		}
+	}
+}
+
+// list returns the recorded changes, oldest first.
+func (l *auditLog) list() []auditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append(make([]auditEntry, 0, len(l.entries)), l.entries...)
+}
+
+func (l *auditLog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+type auditChangeKey struct{}
+
+// auditChange is the state an admin call changed, as reported by its handler.
+type auditChange struct {
+	before, after interface{}
+}
+
+// recordChange reports the part of the state an admin call changed. Handlers
+// capture it together with the change, under the lock of the part they
+// change, instead of the whole state being compared around every call.
+func recordChange(r *http.Request, before, after interface{}) {
+	if c, ok := r.Context().Value(auditChangeKey{}).(*auditChange); ok {
+		c.before, c.after = before, after
+	}
+}
+
+// decodedJSON round-trips v through JSON so structs compare like decoded maps.
+func decodedJSON(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var decoded interface{}
+	json.Unmarshal(data, &decoded)
+	return decoded
+}
+
+// diffJSON returns the parts of two decoded JSON values that differ. Objects
+// are compared key by key; anything else is reported whole.
+func diffJSON(before, after interface{}) (interface{}, interface{}, bool) {
+	b, bok := before.(map[string]interface{})
+	a, aok := after.(map[string]interface{})
+	if !bok || !aok {
+		if reflect.DeepEqual(before, after) {
+			return nil, nil, false
+		}
+		return before, after, true
+	}
+	bOut, aOut := make(map[string]interface{}), make(map[string]interface{})
+	for k, bv := range b {
+		av, ok := a[k]
+		if !ok {
+			bOut[k] = bv
+			continue
+		}
+		if bd, ad, changed := diffJSON(bv, av); changed {
+			bOut[k], aOut[k] = bd, ad
+		}
+	}
+	for k, av := range a {
+		if _, ok := b[k]; !ok {
+			aOut[k] = av
+		}
+	}
+	if len(bOut) == 0 && len(aOut) == 0 {
+		return nil, nil, false
+	}
+	return bOut, aOut, true
+}
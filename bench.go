@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runBenchCommand sends requests from concurrent workers to a running server
+// and reports throughput and latency, e.g. to compare LOG_WORKERS settings:
+//
+//	dummy-logger-server bench -url http://localhost:8080/users -c 50 -d 10s
+func runBenchCommand(args []string) error {
+	port := "8080"
+	if envPort := os.Getenv("PORT"); envPort != "" {
+		port = envPort
+	}
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	target := fs.String("url", "http://localhost:"+port+"/users", "URL to send requests to")
+	method := fs.String("method", "GET", "request method")
+	body := fs.String("body", "", "request body (sent as application/json)")
+	concurrency := fs.Int("c", 20, "number of concurrent workers")
+	duration := fs.Duration("d", 10*time.Second, "how long to run")
+	total := fs.Int("n", 0, "stop after this many requests (0: run for -d)")
+	headers := headerFlags{}
+	fs.Var(headers, "header", "request header \"Name: value\" (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: dummy-logger-server bench [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *concurrency < 1 {
+		return fmt.Errorf("-c must be at least 1")
+	}
+
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency},
+	}
+	var (
+		sent      int64
+		errs      int64
+		mu        sync.Mutex
+		latencies []time.Duration
+		statuses  = make(map[int]int)
+		wg        sync.WaitGroup
+	)
+	deadline := time.Now().Add(*duration)
+	start := time.Now()
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var local []time.Duration
+			localStatuses := make(map[int]int)
+			for time.Now().Before(deadline) {
+				if *total > 0 && atomic.AddInt64(&sent, 1) > int64(*total) {
+					break
+				}
+				req, err := http.NewRequest(*method, *target, bytes.NewReader([]byte(*body)))
+				if err != nil {
+					atomic.AddInt64(&errs, 1)
+					return
+				}
+				if *body != "" {
+					req.Header.Set("Content-Type", "application/json")
+				}
+				for k, v := range headers {
+					req.Header.Set(k, v)
+				}
+				t := time.Now()
+				resp, err := client.Do(req)
+				if err != nil {
+					atomic.AddInt64(&errs, 1)
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				local = append(local, time.Since(t))
+				localStatuses[resp.StatusCode]++
+			}
+			mu.Lock()
+			latencies = append(latencies, local...)
+			for code, n := range localStatuses {
+				statuses[code] += n
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if len(latencies) == 0 {
+		return fmt.Errorf("no successful requests (%d errors)", errs)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	pct := func(p float64) time.Duration {
+		return latencies[int(float64(len(latencies)-1)*p)]
+	}
+	fmt.Printf("%s %s, %d workers, %v\n", *method, *target, *concurrency, elapsed.Round(time.Millisecond))
+	fmt.Printf("Requests:   %d (%d errors)\n", len(latencies), errs)
+	fmt.Printf("Throughput: %.1f req/s\n", float64(len(latencies))/elapsed.Seconds())
+	fmt.Printf("Latency:    p50 %v  p90 %v  p99 %v  max %v\n", pct(0.5), pct(0.9), pct(0.99), latencies[len(latencies)-1])
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Printf("Status %d:  %d\n", code, statuses[code])
+	}
+	return nil
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const cliUsage = `usage: dummy-logger-server [command] [flags]
+
+Commands:
+  serve                               run the server (default)
+  mocks list|add|delete|clear         manage runtime mocks
+  requests tail|search|clear          inspect captured requests
+  verify                              assert how many captured requests match
+  reset                               restore the namespace to its startup state
+  snapshot save|restore FILE          save or restore the full mock state
+  export                              turn captured requests into mock definitions
+  audit                               list changes made through the admin API
+  clock show|set|advance|freeze|reset show or control the mock clock
+  bench                               load-test a URL and report throughput and latency
+
+Every command accepts -server URL, -session NAME, -token TOKEN and -json.
+-server is the admin listener (http(s)://host:port or unix:/path/to.sock).
+Run "dummy-logger-server COMMAND -h" for the command's flags.
+`
+
+// defaultServerURL is where CLI commands find the admin API of a running server
+// unless -server is given: MOCK_ADMIN_URL, or the server's own ADMIN_SOCKET/ADMIN_ADDR.
+func defaultServerURL() string {
+	if url := os.Getenv("MOCK_ADMIN_URL"); url != "" {
+		return url
+	}
+	if socket := os.Getenv("ADMIN_SOCKET"); socket != "" {
+		return "unix:" + socket
+	}
+	scheme := "http"
+	if os.Getenv("ADMIN_TLS_CERT") != "" {
+		scheme = "https"
+	}
+	addr := os.Getenv("ADMIN_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:9090"
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return scheme + "://" + addr
+}
+
+// defaultToken is the admin token sent unless -token is given.
+func defaultToken() string {
+	if token := os.Getenv("MOCK_ADMIN_TOKEN"); token != "" {
+		return token
+	}
+	return os.Getenv("ADMIN_TOKEN")
+}
+
+// runCommand dispatches a control subcommand.
+func runCommand(name string, args []string) error {
+	switch name {
+	case "mocks":
+		return runMocksCommand(args)
+	case "requests":
+		return runRequestsCommand(args)
+	case "verify":
+		return runVerifyCommand(args)
+	case "reset":
+		return runResetCommand(args)
+	case "snapshot":
+		return runSnapshotCommand(args)
+	case "export":
+		return runExportCommand(args)
+	case "audit":
+		return runAuditCommand(args)
+	case "bench":
+		return runBenchCommand(args)
+	case "clock":
+		return runClockCommand(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(cliUsage)
+		return nil
+	}
+	fmt.Fprint(os.Stderr, cliUsage)
+	return fmt.Errorf("unknown command %q", name)
+}
+
+// splitAction separates a leading action word ("list", "tail", ...) from the flags after it.
+func splitAction(args []string) (string, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
+	}
+	return "", args
+}
+
+// adminClient calls the admin API of a running server.
+type adminClient struct {
+	base    string
+	session string
+	token   string
+	json    bool
+	caFile  string
+	cert    string
+	key     string
+	http    *http.Client
+	url     string // resolved base URL, set with http on first call
+}
+
+// newCommandFlags returns a flag set with the flags shared by all commands.
+func newCommandFlags(name, usage string) (*flag.FlagSet, *adminClient) {
+	c := &adminClient{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&c.base, "server", defaultServerURL(), "admin API URL of the running server, or unix:/path/to.sock")
+	fs.StringVar(&c.session, "session", "", "namespace to act on (through the /__ns/NAME path prefix)")
+	fs.StringVar(&c.token, "token", defaultToken(), "admin token (default $MOCK_ADMIN_TOKEN or $ADMIN_TOKEN)")
+	fs.BoolVar(&c.json, "json", false, "print JSON instead of a table")
+	fs.StringVar(&c.caFile, "cacert", os.Getenv("MOCK_ADMIN_CA"), "CA certificate to verify an https admin API")
+	fs.StringVar(&c.cert, "cert", os.Getenv("MOCK_ADMIN_CERT"), "client certificate for mTLS")
+	fs.StringVar(&c.key, "key", os.Getenv("MOCK_ADMIN_KEY"), "client key for mTLS")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: dummy-logger-server %s\n", usage)
+		fs.PrintDefaults()
+	}
+	return fs, c
+}
+
+// connect builds the HTTP client for the -server, -cacert, -cert and -key flags.
+func (c *adminClient) connect() error {
+	base := strings.TrimRight(c.base, "/")
+	tr := &http.Transport{}
+	if socket, ok := strings.CutPrefix(base, "unix:"); ok {
+		tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		base = "http://admin"
+	}
+	if c.caFile != "" || c.cert != "" {
+		cfg := &tls.Config{}
+		if c.caFile != "" {
+			pem, err := os.ReadFile(c.caFile)
+			if err != nil {
+				return err
+			}
+			cfg.RootCAs = x509.NewCertPool()
+			if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates found in %s", c.caFile)
+			}
+		}
+		if c.cert != "" {
+			cert, err := tls.LoadX509KeyPair(c.cert, c.key)
+			if err != nil {
+				return err
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		tr.TLSClientConfig = cfg
+	}
+	c.http = &http.Client{Timeout: 30 * time.Second, Transport: tr}
+	c.url = base
+	return nil
+}
+
+// call sends a request to the admin API and decodes a JSON response into out.
+func (c *adminClient) call(method, path string, body []byte, out interface{}) error {
+	if c.http == nil {
+		if err := c.connect(); err != nil {
+			return err
+		}
+	}
+	// The /__ns/ prefix selects the namespace whatever NAMESPACE_HEADER the
+	// server uses
+	prefix := ""
+	if c.session != "" {
+		prefix = namespacePathPrefix + url.PathEscape(c.session)
+	}
+	req, err := http.NewRequest(method, c.url+prefix+adminPrefix+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("server returned %s: %s", resp.Status, apiErr.Message)
+		}
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if raw, ok := out.(*json.RawMessage); ok {
+		*raw = data
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+// headerFlags collects repeated -header "Name: value" flags.
+type headerFlags map[string]string
+
+func (h headerFlags) String() string { return fmt.Sprint(map[string]string(h)) }
+
+func (h headerFlags) Set(v string) error {
+	name, value, ok := strings.Cut(v, ":")
+	if !ok {
+		return fmt.Errorf("header must look like \"Name: value\"")
+	}
+	h[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	return nil
+}
+
+func runMocksCommand(args []string) error {
+	fs, c := newCommandFlags("mocks", "mocks [flags] list | add [FILE|-] | delete ID | clear")
+	method := fs.String("method", "", "add: request method to match (default any)")
+	path := fs.String("path", "", "add: request path to match, e.g. /users/{id}")
+	status := fs.Int("status", http.StatusOK, "add: response status")
+	body := fs.String("body", "", "add: response body (JSON, or plain text)")
+	delay := fs.Int("delay", 0, "add: response delay in milliseconds")
+	headers := headerFlags{}
+	fs.Var(headers, "header", "add: response header \"Name: value\" (repeatable)")
+	action, rest := splitAction(args)
+	if err := fs.Parse(rest); err != nil {
+		return err
+	}
+
+	switch action {
+	case "list", "":
+		var mocks []mockDef
+		if err := c.call("GET", "/mocks", nil, &mocks); err != nil {
+			return err
+		}
+		if c.json {
+			return printJSON(mocks)
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "ID\tMETHOD\tPATH\tSTATUS\tHITS")
+		for _, m := range mocks {
+			method := m.Method
+			if method == "" {
+				method = "*"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\n", m.ID, method, m.Path, m.Status, m.Hits)
+		}
+		return tw.Flush()
+	case "add":
+		var payload []byte
+		if file := fs.Arg(0); file != "" {
+			var err error
+			if file == "-" {
+				payload, err = io.ReadAll(os.Stdin)
+			} else {
+				payload, err = os.ReadFile(file)
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			if *path == "" {
+				return fmt.Errorf("mocks add needs a definition file or -path")
+			}
+			def := mockDef{Method: *method, Path: *path, Status: *status, Headers: headers, DelayMs: *delay}
+			if *body != "" {
+				if json.Valid([]byte(*body)) {
+					def.Body = json.RawMessage(*body)
+				} else {
+					def.Body, _ = json.Marshal(*body)
+				}
+			}
+			payload, _ = json.Marshal(def)
+		}
+		var added []mockDef
+		if err := c.call("POST", "/mocks", payload, &added); err != nil {
+			return err
+		}
+		if c.json {
+			return printJSON(added)
+		}
+		for _, m := range added {
+			fmt.Printf("Added %s\n", m.ID)
+		}
+		return nil
+	case "delete":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("mocks delete needs a mock ID")
+		}
+		if err := c.call("DELETE", "/mocks/"+url.PathEscape(fs.Arg(0)), nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %s\n", fs.Arg(0))
+		return nil
+	case "clear":
+		if err := c.call("DELETE", "/mocks", nil, nil); err != nil {
+			return err
+		}
+		fmt.Println("Cleared all runtime mocks")
+		return nil
+	}
+	fs.Usage()
+	return fmt.Errorf("unknown mocks action %q", action)
+}
+
+// addFilterFlags registers the journal filter flags shared by requests and verify.
+func addFilterFlags(fs *flag.FlagSet) *journalFilter {
+	f := &journalFilter{}
+	fs.StringVar(&f.Method, "method", "", "match the request method")
+	fs.StringVar(&f.Path, "path", "", "match the request path, e.g. /users/{id}")
+	fs.IntVar(&f.Status, "status", 0, "match the response status")
+	fs.StringVar(&f.Contains, "contains", "", "match a substring of the request body")
+	return f
+}
+
+func (f journalFilter) query() string {
+	q := url.Values{}
+	if f.Method != "" {
+		q.Set("method", f.Method)
+	}
+	if f.Path != "" {
+		q.Set("path", f.Path)
+	}
+	if f.Status != 0 {
+		q.Set("status", strconv.Itoa(f.Status))
+	}
+	if f.Contains != "" {
+		q.Set("contains", f.Contains)
+	}
+	if f.SinceID != 0 {
+		q.Set("since_id", strconv.FormatInt(f.SinceID, 10))
+	}
+	return q.Encode()
+}
+
+func runRequestsCommand(args []string) error {
+	fs, c := newCommandFlags("requests", "requests [flags] tail | search | clear")
+	filter := addFilterFlags(fs)
+	last := fs.Int("n", 10, "tail: number of existing requests to show first")
+	interval := fs.Duration("interval", time.Second, "tail: polling interval")
+	action, rest := splitAction(args)
+	if err := fs.Parse(rest); err != nil {
+		return err
+	}
+
+	switch action {
+	case "search", "":
+		var entries []journalEntry
+		if err := c.call("GET", "/requests?"+filter.query(), nil, &entries); err != nil {
+			return err
+		}
+		if c.json {
+			return printJSON(entries)
+		}
+		return printRequests(entries, true)
+	case "tail":
+		var entries []journalEntry
+		if err := c.call("GET", "/requests?"+filter.query()+"&limit="+strconv.Itoa(*last), nil, &entries); err != nil {
+			return err
+		}
+		header := true
+		for {
+			if len(entries) > 0 {
+				filter.SinceID = entries[len(entries)-1].ID
+			}
+			if c.json {
+				for _, e := range entries {
+					line, _ := json.Marshal(e)
+					fmt.Println(string(line))
+				}
+			} else if err := printRequests(entries, header); err != nil {
+				return err
+			}
+			header = false
+			time.Sleep(*interval)
+			entries = nil
+			if err := c.call("GET", "/requests?"+filter.query(), nil, &entries); err != nil {
+				return err
+			}
+		}
+	case "clear":
+		if err := c.call("DELETE", "/requests", nil, nil); err != nil {
+			return err
+		}
+		fmt.Println("Cleared captured requests")
+		return nil
+	}
+	fs.Usage()
+	return fmt.Errorf("unknown requests action %q", action)
+}
+
+// printRequests uses fixed-width columns so rows printed while tailing line up with the header.
+func printRequests(entries []journalEntry, header bool) error {
+	const row = "%-6v  %-30s  %-7s  %-40s  %-6v  %s\n"
+	if header {
+		fmt.Printf(row, "ID", "TIME", "METHOD", "PATH", "STATUS", "DURATION")
+	}
+	for _, e := range entries {
+		path := e.Path
+		if e.Query != "" {
+			path += "?" + e.Query
+		}
+		fmt.Printf(row, e.ID, e.Time, e.Method, path, e.Status, fmt.Sprintf("%.1fms", e.DurationMs))
+	}
+	return nil
+}
+
+func runVerifyCommand(args []string) error {
+	fs, c := newCommandFlags("verify", "verify [flags]")
+	filter := addFilterFlags(fs)
+	count := fs.Int("count", -1, "expect exactly this many matching requests")
+	atLeast := fs.Int("at-least", -1, "expect at least this many matching requests")
+	atMost := fs.Int("at-most", -1, "expect at most this many matching requests")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	req := verifyRequest{journalFilter: *filter}
+	if *count >= 0 {
+		req.Count = count
+	}
+	if *atLeast >= 0 {
+		req.AtLeast = atLeast
+	}
+	if *atMost >= 0 {
+		req.AtMost = atMost
+	}
+	payload, _ := json.Marshal(req)
+	var result struct {
+		OK       bool   `json:"ok"`
+		Matched  int    `json:"matched"`
+		Expected string `json:"expected"`
+	}
+	if err := c.call("POST", "/verify", payload, &result); err != nil {
+		return err
+	}
+	if c.json {
+		if err := printJSON(result); err != nil {
+			return err
+		}
+	} else if result.OK {
+		fmt.Printf("OK: %d matching request(s), expected %s\n", result.Matched, result.Expected)
+	}
+	if !result.OK {
+		return fmt.Errorf("verification failed: %d matching request(s), expected %s", result.Matched, result.Expected)
+	}
+	return nil
+}
+
+func runResetCommand(args []string) error {
+	fs, c := newCommandFlags("reset", "reset [flags]")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := c.call("POST", "/reset", nil, nil); err != nil {
+		return err
+	}
+	fmt.Println("Reset to the startup state")
+	return nil
+}
+
+// runSnapshotCommand implements "snapshot save FILE" and "snapshot restore FILE".
+func runSnapshotCommand(args []string) error {
+	fs, c := newCommandFlags("snapshot", "snapshot [flags] save|restore FILE")
+	action, rest := splitAction(args)
+	if err := fs.Parse(rest); err != nil {
+		return err
+	}
+	if action == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected an action and a file")
+	}
+	file := fs.Arg(0)
+
+	switch action {
+	case "save":
+		var snap json.RawMessage
+		if err := c.call("GET", "/snapshot", nil, &snap); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, snap, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("Saved snapshot to %s\n", file)
+	case "restore":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := c.call("POST", "/restore", data, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Restored snapshot from %s\n", file)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown snapshot action %q", action)
+	}
+	return nil
+}
+
+func runExportCommand(args []string) error {
+	fs, c := newCommandFlags("export", "export [flags]")
+	out := fs.String("o", "", "write the mock definitions to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var defs []mockDef
+	if err := c.call("GET", "/export", nil, &defs); err != nil {
+		return err
+	}
+	if *out == "" {
+		if c.json {
+			return printJSON(defs)
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "METHOD\tPATH\tSTATUS")
+		for _, d := range defs {
+			fmt.Fprintf(tw, "%s\t%s\t%d\n", d.Method, d.Path, d.Status)
+		}
+		return tw.Flush()
+	}
+	data, err := json.MarshalIndent(defs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d mock definition(s) to %s\n", len(defs), *out)
+	return nil
+}
+
+func runAuditCommand(args []string) error {
+	fs, c := newCommandFlags("audit", "audit [flags]")
+	limit := fs.Int("n", 20, "number of most recent changes to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var entries []auditEntry
+	if err := c.call("GET", "/audit?limit="+strconv.Itoa(*limit), nil, &entries); err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(entries)
+	}
+	tw := newTable()
+	fmt.Fprintln(tw, "ID\tTIME\tWHO\tMETHOD\tPATH\tNAMESPACE\tSTATUS")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%d\n", e.ID, e.Time, e.Who, e.Method, e.Path, e.Namespace, e.Status)
+	}
+	return tw.Flush()
+}
+
+// runClockCommand shows the mock clock or changes it.
+func runClockCommand(args []string) error {
+	fs, c := newCommandFlags("clock", "clock [flags] [show|set TIME|advance DURATION|freeze|unfreeze|reset]")
+	action, rest := splitAction(args)
+	if err := fs.Parse(rest); err != nil {
+		return err
+	}
+	var change clockChange
+	method := "PUT"
+	switch action {
+	case "show", "":
+		method = "GET"
+	case "set", "advance":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return fmt.Errorf("%s needs one argument", action)
+		}
+		if action == "set" {
+			change.Time = fs.Arg(0)
+		} else {
+			change.Advance = fs.Arg(0)
+		}
+	case "freeze", "unfreeze":
+		frozen := action == "freeze"
+		change.Frozen = &frozen
+	case "reset":
+		method = "DELETE"
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown clock action %q", action)
+	}
+	var body []byte
+	if method == "PUT" {
+		body, _ = json.Marshal(change)
+	}
+	var state map[string]interface{}
+	if err := c.call(method, "/clock", body, &state); err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(state)
+	}
+	mode := "running"
+	if state["frozen"] == true {
+		mode = "frozen"
+	}
+	fmt.Printf("%s (%s, offset %s)\n", state["now"], mode, state["offset"])
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ipNetworks is a list of networks such as TRUSTED_PROXIES or an allow list.
+type ipNetworks []*net.IPNet
+
+// proxies are the networks (TRUSTED_PROXIES) whose forwarding headers and
+// PROXY protocol headers are believed.
+var proxies ipNetworks
+
+// forwardedHeader is the one header the trusted proxies set (FORWARDED_HEADER):
+// X-Forwarded-For, Forwarded or X-Real-IP. Other forwarding headers are
+// passed through by most proxies untouched, so a client could forge them.
+var forwardedHeader = "X-Forwarded-For"
+
+// parseForwardedHeader validates a FORWARDED_HEADER setting.
+func parseForwardedHeader(name string) (string, error) {
+	name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+	switch name {
+	case "X-Forwarded-For", "Forwarded", "X-Real-Ip":
+		return name, nil
+	}
+	return "", fmt.Errorf("%q is not one of X-Forwarded-For, Forwarded or X-Real-IP", name)
+}
+
+// parseIPNetworks reads a comma-separated list of CIDRs and addresses,
+// e.g. "10.0.0.0/8,192.168.1.5,fd00::/8".
+func parseIPNetworks(spec string) (ipNetworks, error) {
+	var t ipNetworks
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR", item)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 128
+			}
+			item = fmt.Sprintf("%s/%d", item, bits)
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR", item)
+		}
+		t = append(t, network)
+	}
+	return t, nil
+}
+
+func (t ipNetworks) contains(ip net.IP) bool {
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAddr reports whether addr ("ip", "ip:port" or "[ipv6]:port") is in the list.
+func (t ipNetworks) containsAddr(addr string) bool {
+	ip := net.ParseIP(hostOnly(addr))
+	return ip != nil && t.contains(ip)
+}
+
+// hostOnly strips the port and IPv6 brackets from an address.
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+// clientAddress is the address of the client that made r. When the peer is
+// a trusted proxy, the forwarding chain in forwardedHeader is walked from the
+// nearest hop and the first address not belonging to a trusted proxy is the
+// client. Without trusted proxies forwarding headers are ignored, since any
+// client can send them.
+func clientAddress(r *http.Request) string {
+	peer := hostOnly(r.RemoteAddr)
+	if len(proxies) == 0 || !proxies.containsAddr(peer) {
+		return peer
+	}
+	chain := forwardedChain(r.Header, forwardedHeader)
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := hostOnly(chain[i])
+		if ip := net.ParseIP(hop); ip == nil || !proxies.contains(ip) {
+			// Obfuscated or "unknown" identifiers are reported as they are
+			return hop
+		}
+	}
+	if len(chain) > 0 {
+		// Every hop is a proxy: the farthest one is as close as we get
+		return hostOnly(chain[0])
+	}
+	return peer
+}
+
+// forwardedChain lists the client and proxy addresses a request passed
+// through according to header, farthest first.
+func forwardedChain(h http.Header, header string) []string {
+	var chain []string
+	switch header {
+	case "Forwarded":
+		for _, element := range strings.Split(strings.Join(h.Values("Forwarded"), ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					chain = append(chain, strings.Trim(value, `"`))
+				}
+			}
+		}
+	case "X-Forwarded-For":
+		for _, hop := range strings.Split(strings.Join(h.Values("X-Forwarded-For"), ","), ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				chain = append(chain, hop)
+			}
+		}
+	default:
+		if ip := strings.TrimSpace(h.Get(header)); ip != "" {
+			chain = append(chain, ip)
+		}
+	}
+	return chain
+}
+
+type socketPeerKey struct{}
+
+// socketPeerContext records the proxy's socket address for connections that
+// arrived with a PROXY protocol header, whose RemoteAddr is the client's.
+func socketPeerContext(ctx context.Context, c net.Conn) context.Context {
+	for c != nil {
+		if pc, ok := c.(*proxyConn); ok {
+			return context.WithValue(ctx, socketPeerKey{}, pc.peer().String())
+		}
+		c = unwrapConn(c)
+	}
+	return ctx
+}
+
+// socketPeer is the address of the socket r arrived on.
+func socketPeer(r *http.Request) string {
+	if peer, ok := r.Context().Value(socketPeerKey{}).(string); ok {
+		return peer
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientAddress(t *testing.T) {
+	trusted, err := parseIPNetworks("10.0.0.0/8,127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(p ipNetworks, h string) { proxies, forwardedHeader = p, h }(proxies, forwardedHeader)
+
+	tests := []struct {
+		name    string
+		proxies ipNetworks
+		header  string // FORWARDED_HEADER
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"no trusted proxies ignores headers", nil, "X-Forwarded-For", "127.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"}, "127.0.0.1"},
+		{"untrusted peer ignores headers", trusted, "X-Forwarded-For", "192.0.2.9:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"}, "192.0.2.9"},
+		{"nearest untrusted hop", trusted, "X-Forwarded-For", "127.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "203.0.113.5, 198.51.100.1, 10.1.1.1"}, "198.51.100.1"},
+		{"all hops trusted", trusted, "X-Forwarded-For", "127.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "10.2.2.2, 10.1.1.1"}, "10.2.2.2"},
+		{"forged Forwarded is ignored with X-Forwarded-For", trusted, "X-Forwarded-For", "127.0.0.1:5000",
+			map[string]string{"Forwarded": "for=10.0.0.1", "X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"Forwarded with IPv6 and port", trusted, "Forwarded", "127.0.0.1:5000",
+			map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https, for=10.3.3.3`}, "2001:db8::1"},
+		{"forged X-Forwarded-For is ignored with Forwarded", trusted, "Forwarded", "127.0.0.1:5000",
+			map[string]string{"Forwarded": "for=198.51.100.7", "X-Forwarded-For": "10.0.0.1"}, "198.51.100.7"},
+		{"X-Real-IP", trusted, "X-Real-Ip", "127.0.0.1:5000",
+			map[string]string{"X-Real-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"no header keeps the peer", trusted, "X-Forwarded-For", "10.9.9.9:80", nil, "10.9.9.9"},
+	}
+	for _, tt := range tests {
+		proxies, forwardedHeader = tt.proxies, tt.header
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got := clientAddress(r); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseForwardedHeader(t *testing.T) {
+	for in, want := range map[string]string{"x-forwarded-for": "X-Forwarded-For", "forwarded": "Forwarded", "X-Real-IP": "X-Real-Ip"} {
+		if got, err := parseForwardedHeader(in); err != nil || got != want {
+			t.Errorf("%q: got %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := parseForwardedHeader("X-Client-IP"); err == nil {
+		t.Error("X-Client-IP: accepted, want an error")
+	}
+}
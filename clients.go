@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientConfig restricts who may call the main listener and tailors the
+// responses per caller, read from CLIENTS_FILE:
+//
+//	{
+//	  "allow": ["10.0.0.0/8", "203.0.113.0/24"],
+//	  "deny": ["10.66.0.0/16"],
+//	  "profiles": [
+//	    {"name": "acme", "match": {"ips": ["203.0.113.0/24"], "api_keys": ["acme-key"]},
+//	     "layer": "acme", "latency_ms": 200, "jitter_ms": 50,
+//	     "faults": {"error_rate": 0.05, "statuses": [502, 503]},
+//	     "rate_limit": {"requests": 10, "per": "1s"}},
+//	    {"name": "globex", "match": {"headers": {"X-Partner": "globex"}}, "layer": "globex"},
+//	    {"name": "default", "latency_ms": 20}
+//	  ]
+//	}
+//
+// Addresses are the client addresses resolved through TRUSTED_PROXIES. Deny
+// wins over allow; an empty allow list allows everyone. The first profile
+// with a matching IP, API key (X-Api-Key or ?api_key=) or set of headers
+// applies; a profile without match criteria applies to every caller.
+type clientConfig struct {
+	Allow    []string        `json:"allow"`
+	Deny     []string        `json:"deny"`
+	Profiles []clientProfile `json:"profiles"`
+
+	allow ipNetworks
+	deny  ipNetworks
+}
+
+// clientProfile is the behavior selected for a group of callers.
+type clientProfile struct {
+	Name      string          `json:"name"`
+	Match     clientMatch     `json:"match"`
+	Layer     string          `json:"layer,omitempty"` // response layer stacked on base, see RESPONSE_LAYERS
+	LatencyMs int             `json:"latency_ms,omitempty"`
+	JitterMs  int             `json:"jitter_ms,omitempty"`
+	Faults    *clientFaults   `json:"faults,omitempty"`
+	RateLimit *clientRateSpec `json:"rate_limit,omitempty"`
+
+	ips     ipNetworks
+	limiter *rateLimiter
+}
+
+type clientMatch struct {
+	IPs     []string          `json:"ips,omitempty"`
+	APIKeys []string          `json:"api_keys,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// clientFaults answers a share of the requests with an error status.
+type clientFaults struct {
+	ErrorRate float64 `json:"error_rate"`
+	Statuses  []int   `json:"statuses,omitempty"` // picked at random, default 500
+}
+
+// clientRateSpec allows Requests per Per ("1s", "1m"), with bursts of up to Requests.
+type clientRateSpec struct {
+	Requests int    `json:"requests"`
+	Per      string `json:"per"`
+}
+
+// loadClientConfig reads CLIENTS_FILE.
+func loadClientConfig(path string) (*clientConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c clientConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if c.allow, err = parseIPNetworks(strings.Join(c.Allow, ",")); err != nil {
+		return nil, fmt.Errorf("%s: allow: %w", path, err)
+	}
+	if c.deny, err = parseIPNetworks(strings.Join(c.Deny, ",")); err != nil {
+		return nil, fmt.Errorf("%s: deny: %w", path, err)
+	}
+	for i := range c.Profiles {
+		p := &c.Profiles[i]
+		if p.Name == "" {
+			return nil, fmt.Errorf("%s: profile %d has no name", path, i)
+		}
+		if p.ips, err = parseIPNetworks(strings.Join(p.Match.IPs, ",")); err != nil {
+			return nil, fmt.Errorf("%s: profile %s: %w", path, p.Name, err)
+		}
+		if f := p.Faults; f != nil {
+			if f.ErrorRate < 0 || f.ErrorRate > 1 {
+				return nil, fmt.Errorf("%s: profile %s: error_rate must be between 0 and 1", path, p.Name)
+			}
+			for _, status := range f.Statuses {
+				if status < 400 || status > 599 {
+					return nil, fmt.Errorf("%s: profile %s: fault status %d is not an error status", path, p.Name, status)
+				}
+			}
+		}
+		if rl := p.RateLimit; rl != nil {
+			per, err := time.ParseDuration(rl.Per)
+			if err != nil || per <= 0 || rl.Requests < 1 {
+				return nil, fmt.Errorf("%s: profile %s: rate_limit needs requests >= 1 and a duration like \"1s\"", path, p.Name)
+			}
+			p.limiter = newRateLimiter(rl.Requests, per)
+		}
+	}
+	return &c, nil
+}
+
+// checkLayers reports profiles selecting a response layer that does not exist,
+// checking the stack the profile's requests are served from.
+func (c *clientConfig) checkLayers(layers []responseLayer) error {
+	for _, p := range c.Profiles {
+		if p.Layer == "" {
+			continue
+		}
+		stack, unknown := selectLayers(layers, []string{p.Layer})
+		if len(unknown) > 0 || stack[len(stack)-1].Name != p.Layer {
+			return fmt.Errorf("profile %s selects unknown response layer %q (layers: %s)", p.Name, p.Layer, strings.Join(layerNames(layers), ", "))
+		}
+	}
+	return nil
+}
+
+// clientMatchResult is the profile that applies to a request and why.
+type clientMatchResult struct {
+	profile *clientProfile
+	reason  string
+}
+
+// match returns the first profile for r, or nil.
+func (c *clientConfig) match(r *http.Request, client string) *clientMatchResult {
+	ip := net.ParseIP(client)
+	apiKey := r.Header.Get("X-Api-Key")
+	if apiKey == "" {
+		apiKey = r.URL.Query().Get("api_key")
+	}
+	for i := range c.Profiles {
+		p := &c.Profiles[i]
+		m := p.Match
+		if len(m.IPs) == 0 && len(m.APIKeys) == 0 && len(m.Headers) == 0 {
+			return &clientMatchResult{profile: p, reason: "catch-all"}
+		}
+		if ip != nil && p.ips.contains(ip) {
+			return &clientMatchResult{profile: p, reason: "ip " + client}
+		}
+		if apiKey != "" {
+			for _, key := range m.APIKeys {
+				if key == apiKey {
+					return &clientMatchResult{profile: p, reason: "api key"}
+				}
+			}
+		}
+		if len(m.Headers) > 0 && headersMatch(r.Header, m.Headers) {
+			return &clientMatchResult{profile: p, reason: "headers"}
+		}
+	}
+	return nil
+}
+
+// headersMatch reports whether h has every one of the wanted header values.
+func headersMatch(h http.Header, want map[string]string) bool {
+	for name, value := range want {
+		if h.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// allowed reports whether client may call the server, and the rule that
+// refused it.
+func (c *clientConfig) allowed(client string) (bool, string) {
+	ip := net.ParseIP(client)
+	if ip == nil {
+		// Obfuscated or "unknown" forwarded identifiers cannot be checked
+		// against the lists, so they only pass when there are none
+		return len(c.allow) == 0 && len(c.deny) == 0, "client address is not an IP address"
+	}
+	for _, network := range c.deny {
+		if network.Contains(ip) {
+			return false, "deny " + network.String()
+		}
+	}
+	if len(c.allow) == 0 || c.allow.contains(ip) {
+		return true, ""
+	}
+	return false, "not in the allow list"
+}
+
+type clientProfileKey struct{}
+
+// identify resolves the caller's profile before the request is logged, so
+// the log can say which one applied.
+func (c *clientConfig) identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m := c.match(r, clientAddress(r)); m != nil {
+			r = r.WithContext(context.WithValue(r.Context(), clientProfileKey{}, m))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientProfileFrom returns the profile identify found for r, or nil.
+func clientProfileFrom(r *http.Request) *clientMatchResult {
+	m, _ := r.Context().Value(clientProfileKey{}).(*clientMatchResult)
+	return m
+}
+
+// enforce applies the allow and deny lists and the profile's rate limit,
+// latency and faults.
+func (c *clientConfig) enforce(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientAddress(r)
+		if ok, rule := c.allowed(client); !ok {
+			writeError(w, r, http.StatusForbidden, "Forbidden", fmt.Sprintf("Client %s is not allowed (%s)", client, rule))
+			return
+		}
+		m := clientProfileFrom(r)
+		if m == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p := m.profile
+		if p.limiter != nil {
+			if ok, retry := p.limiter.allow(); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+				writeError(w, r, http.StatusTooManyRequests, "Too Many Requests", fmt.Sprintf("Rate limit of profile %s exceeded: %d request(s) per %s", p.Name, p.RateLimit.Requests, p.RateLimit.Per))
+				return
+			}
+		}
+		rnd := requestRand(r)
+		if p.LatencyMs > 0 || p.JitterMs > 0 {
+			delay := time.Duration(p.LatencyMs) * time.Millisecond
+			if p.JitterMs > 0 {
+				delay += time.Duration(rnd.Intn(p.JitterMs+1)) * time.Millisecond
+			}
+			if !wait(r, delay) {
+				abandon(w, r)
+				return
+			}
+		}
+		if f := p.Faults; f != nil && rnd.Float64() < f.ErrorRate {
+			status := http.StatusInternalServerError
+			if len(f.Statuses) > 0 {
+				status = f.Statuses[rnd.Intn(len(f.Statuses))]
+			}
+			writeError(w, r, status, http.StatusText(status), fmt.Sprintf("Fault injected by client profile %s", p.Name))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimiter is a token bucket holding up to burst requests, refilled at
+// burst per period.
+type rateLimiter struct {
+	mu     sync.Mutex
+	burst  float64
+	rate   float64 // tokens per second
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(burst int, per time.Duration) *rateLimiter {
+	return &rateLimiter{burst: float64(burst), rate: float64(burst) / per.Seconds(), tokens: float64(burst), last: time.Now()}
+}
+
+// allow takes a token, or reports how long until one is available.
+func (l *rateLimiter) allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestClientConfigAllowed(t *testing.T) {
+	networks := func(spec string) ipNetworks {
+		n, err := parseIPNetworks(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	tests := []struct {
+		name        string
+		allow, deny string
+		client      string
+		want        bool
+	}{
+		{"no lists", "", "", "198.51.100.1", true},
+		{"no lists, obfuscated", "", "", "_hidden", true},
+		{"denied", "", "10.66.0.0/16", "10.66.1.1", false},
+		{"not denied", "", "10.66.0.0/16", "10.67.1.1", true},
+		{"deny list, obfuscated", "", "10.66.0.0/16", "_x", false},
+		{"deny list, unknown", "", "10.66.0.0/16", "unknown", false},
+		{"allowed", "203.0.113.0/24", "", "203.0.113.9", true},
+		{"not allowed", "203.0.113.0/24", "", "198.51.100.1", false},
+		{"allow list, obfuscated", "203.0.113.0/24", "", "_x", false},
+		{"deny wins over allow", "10.0.0.0/8", "10.66.0.0/16", "10.66.0.1", false},
+		{"IPv6", "2001:db8::/32", "", "2001:db8::1", true},
+	}
+	for _, tt := range tests {
+		c := &clientConfig{allow: networks(tt.allow), deny: networks(tt.deny)}
+		if got, rule := c.allowed(tt.client); got != tt.want {
+			t.Errorf("%s: allowed(%q) = %t (%s), want %t", tt.name, tt.client, got, rule, tt.want)
+		}
+	}
+}
+
+func TestClientConfigCheckLayers(t *testing.T) {
+	layers := []responseLayer{{Name: "base"}, {Name: "acme"}, {Name: "globex"}}
+	tests := []struct {
+		layer   string
+		wantErr bool
+	}{
+		{"", false},
+		{"base", false},
+		{"acme", false},
+		{"globex", false},
+		{"initech", true},
+	}
+	for _, tt := range tests {
+		c := &clientConfig{Profiles: []clientProfile{{Name: "partner", Layer: tt.layer}}}
+		if err := c.checkLayers(layers); (err != nil) != tt.wantErr {
+			t.Errorf("layer %q: got %v, want error %v", tt.layer, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// mockClock is the time the mock reports: X-Timestamp and Date headers,
+// created_at fields, journal, audit and log timestamps. It follows the wall
+// clock shifted by an offset, or stands still when frozen. Deterministic mode
+// starts it frozen at a fixed instant; the admin API can set, advance,
+// freeze and unfreeze it. Timers such as namespace expiry and cache
+// revalidation keep using the real time.
+type mockClock struct {
+	mu     sync.Mutex
+	frozen bool
+	at     time.Time     // the time while frozen
+	offset time.Duration // added to the wall clock while running
+
+	startFrozen bool
+	startAt     time.Time
+}
+
+// clock is the server-wide mock clock.
+var clock = &mockClock{}
+
+// deterministicSeed seeds generated values in deterministic mode.
+var deterministicSeed int64
+
+// deterministic reports whether DETERMINISTIC=true was set.
+var deterministic bool
+
+// enableDeterministic freezes the clock at start and seeds generated values.
+func enableDeterministic(start time.Time, seed int64) {
+	deterministic = true
+	deterministicSeed = seed
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.startFrozen, clock.startAt = true, start
+	clock.frozen, clock.at, clock.offset = true, start, 0
+}
+
+func (c *mockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frozen {
+		return c.at
+	}
+	return time.Now().Add(c.offset)
+}
+
+func (c *mockClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// set moves the clock to t, keeping it frozen or running.
+func (c *mockClock) set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frozen {
+		c.at = t
+	} else {
+		c.offset = time.Until(t)
+	}
+}
+
+func (c *mockClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frozen {
+		c.at = c.at.Add(d)
+	} else {
+		c.offset += d
+	}
+}
+
+// freeze stops or restarts the clock at its current time.
+func (c *mockClock) freeze(frozen bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if frozen == c.frozen {
+		return
+	}
+	if frozen {
+		c.at = time.Now().Add(c.offset)
+	} else {
+		c.offset = time.Until(c.at)
+	}
+	c.frozen = frozen
+}
+
+// reset returns the clock to its startup state.
+func (c *mockClock) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frozen, c.at, c.offset = c.startFrozen, c.startAt, 0
+}
+
+func (c *mockClock) state() map[string]interface{} {
+	now := c.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]interface{}{
+		"now":           now.Format(time.RFC3339Nano),
+		"frozen":        c.frozen,
+		"offset":        c.offset.String(),
+		"deterministic": deterministic,
+	}
+}
+
+// setting is the clock configuration without the running time, for audit diffs.
+func (c *mockClock) setting() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frozen {
+		return map[string]interface{}{"frozen": true, "at": c.at.Format(time.RFC3339Nano)}
+	}
+	return map[string]interface{}{"frozen": false, "offset": c.offset.Round(time.Second).String()}
+}
+
+// middleware sets the Date header from the mock clock; net/http only adds
+// its own when the handler did not.
+func (c *mockClock) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", c.Now().UTC().Format(http.TimeFormat))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestRand is the random source for values generated for r. In
+// deterministic mode it is seeded from DETERMINISTIC_SEED and the request
+// itself, so the same request gets the same values whatever order requests
+// arrive in.
+func requestRand(r *http.Request) *rand.Rand {
+	if !deterministic {
+		return rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d %s %s?%s\n", deterministicSeed, r.Method, r.URL.Path, r.URL.RawQuery)
+	h.Write(bodyFrom(r).raw)
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// clockLogWriter stamps every log line with the mock clock. It replaces the
+// log package's wall-clock prefix in deterministic mode.
+type clockLogWriter struct {
+	out io.Writer
+}
+
+func (cw clockLogWriter) Write(p []byte) (int, error) {
+	stamp := clock.Now().Format("2006/01/02 15:04:05 ")
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) > 0 {
+			buf.WriteString(stamp)
+			buf.Write(line)
+		}
+	}
+	if _, err := cw.out.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
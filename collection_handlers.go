@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// registerCollectionRoutes wires CRUD and nested routes for every stateful collection.
+// Nested routes (/users/{id}/orders) are served for each declared relation.
+// Handlers work on the store of the request's namespace.
+func registerCollectionRoutes(r *mux.Router, names []string) {
+	for _, name := range names {
+		name := name
+		r.HandleFunc("/"+name, func(w http.ResponseWriter, r *http.Request) {
+			handleList(w, r, name)
+		}).Methods("GET")
+		r.HandleFunc("/"+name, func(w http.ResponseWriter, r *http.Request) {
+			handleCreate(w, r, name)
+		}).Methods("POST")
+		r.HandleFunc("/"+name+"/{id}", func(w http.ResponseWriter, r *http.Request) {
+			handleGet(w, r, name)
+		}).Methods("GET")
+		r.HandleFunc("/"+name+"/{id}", func(w http.ResponseWriter, r *http.Request) {
+			handleUpdate(w, r, name)
+		}).Methods("PUT", "PATCH")
+		r.HandleFunc("/"+name+"/{id}", func(w http.ResponseWriter, r *http.Request) {
+			handleRemove(w, r, name)
+		}).Methods("DELETE")
+		r.HandleFunc("/"+name+"/{id}/{related}", func(w http.ResponseWriter, r *http.Request) {
+			handleRelated(w, r, name)
+		}).Methods("GET")
+	}
+}
+
+func handleList(w http.ResponseWriter, r *http.Request, name string) {
+	s := namespaceFrom(r).store
+	records, err := s.list(name)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	records, ok := paginate(w, r, records)
+	if !ok {
+		return
+	}
+	expand := expandParam(r)
+	out := make([]record, 0, len(records))
+	for _, rec := range records {
+		out = append(out, s.expand(name, rec, expand))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// paginate applies ?offset= and ?limit= and reports the unpaginated size in X-Total-Count.
+func paginate(w http.ResponseWriter, r *http.Request, records []record) ([]record, bool) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(records)))
+	query := r.URL.Query()
+	offset, limit := 0, len(records)
+	for param, dst := range map[string]*int{"offset": &offset, "limit": &limit} {
+		if v := query.Get(param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, r, http.StatusBadRequest, "Bad Request", "Query parameter "+param+" must be a non-negative integer")
+				return nil, false
+			}
+			*dst = n
+		}
+	}
+	if offset > len(records) {
+		offset = len(records)
+	}
+	// Clamp before adding so a huge limit cannot overflow
+	if limit > len(records)-offset {
+		limit = len(records) - offset
+	}
+	return records[offset : offset+limit], true
+}
+
+func handleGet(w http.ResponseWriter, r *http.Request, name string) {
+	s := namespaceFrom(r).store
+	rec, err := s.get(name, mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.expand(name, rec, expandParam(r)))
+}
+
+func handleCreate(w http.ResponseWriter, r *http.Request, name string) {
+	s := namespaceFrom(r).store
+	rec, ok := decodeRecord(w, r)
+	if !ok {
+		return
+	}
+	created, err := s.create(name, rec)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, s.expand(name, created, expandParam(r)))
+}
+
+func handleUpdate(w http.ResponseWriter, r *http.Request, name string) {
+	s := namespaceFrom(r).store
+	rec, ok := decodeRecord(w, r)
+	if !ok {
+		return
+	}
+	updated, err := s.update(name, mux.Vars(r)["id"], rec, r.Method == http.MethodPatch)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.expand(name, updated, expandParam(r)))
+}
+
+func handleRemove(w http.ResponseWriter, r *http.Request, name string) {
+	s := namespaceFrom(r).store
+	removed, err := s.delete(name, mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if len(removed) > 1 {
+		log.Printf("Cascade delete removed %d records: %v", len(removed), removed)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleRelated(w http.ResponseWriter, r *http.Request, name string) {
+	s := namespaceFrom(r).store
+	vars := mux.Vars(r)
+	child := vars["related"]
+	records, err := s.related(name, vars["id"], child)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	expand := expandParam(r)
+	out := make([]record, 0, len(records))
+	for _, rec := range records {
+		out = append(out, s.expand(child, rec, expand))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// expandParam parses ?expand=user,products.
+func expandParam(r *http.Request) []string {
+	var names []string
+	for _, v := range r.URL.Query()["expand"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// decodeRecord uses the body parsed by the logging middleware; the copy keeps
+// the shared value unchanged.
+func decodeRecord(w http.ResponseWriter, r *http.Request) (record, bool) {
+	payload, err := bodyFrom(r).json()
+	obj, ok := payload.(map[string]interface{})
+	if err != nil || !ok {
+		writeError(w, r, http.StatusBadRequest, "Bad Request", "Request body must be a JSON object")
+		return nil, false
+	}
+	return copyRecord(obj), true
+}
+
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	var conflict *conflictError
+	var invalid *validationError
+	var bad *badRequestError
+	switch {
+	case errors.Is(err, errNotFound):
+		writeError(w, r, http.StatusNotFound, "Not Found", "The requested resource could not be found")
+	case errors.As(err, &conflict):
+		writeError(w, r, http.StatusConflict, "Conflict", conflict.msg)
+	case errors.As(err, &invalid):
+		writeError(w, r, http.StatusUnprocessableEntity, "Unprocessable Entity", invalid.msg)
+	case errors.As(err, &bad):
+		writeError(w, r, http.StatusBadRequest, "Bad Request", bad.msg)
+	default:
+		writeError(w, r, http.StatusInternalServerError, "Internal Server Error", err.Error())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds with the same error shape as the /error/* endpoints.
+func writeError(w http.ResponseWriter, r *http.Request, status int, title, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error":   title,
+		"message": message,
+		"status":  status,
+		"path":    r.URL.Path,
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	records := make([]record, 5)
+	for i := range records {
+		records[i] = record{"id": i}
+	}
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantIDs    []int
+	}{
+		{"", 200, []int{0, 1, 2, 3, 4}},
+		{"?limit=2", 200, []int{0, 1}},
+		{"?offset=3", 200, []int{3, 4}},
+		{"?offset=3&limit=10", 200, []int{3, 4}},
+		{"?offset=9", 200, nil},
+		{"?limit=9223372036854775807&offset=1", 200, []int{1, 2, 3, 4}},
+		{"?limit=-1", 400, nil},
+		{"?offset=x", 400, nil},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		got, ok := paginate(w, httptest.NewRequest("GET", "/users"+tt.query, nil), records)
+		if !ok {
+			if tt.wantStatus == 200 || w.Code != tt.wantStatus {
+				t.Errorf("%q: status %d, want %d", tt.query, w.Code, tt.wantStatus)
+			}
+			continue
+		}
+		if tt.wantStatus != 200 {
+			t.Errorf("%q: accepted, want status %d", tt.query, tt.wantStatus)
+			continue
+		}
+		if len(got) != len(tt.wantIDs) {
+			t.Errorf("%q: got %d records, want %v", tt.query, len(got), tt.wantIDs)
+			continue
+		}
+		for i, rec := range got {
+			if rec["id"] != tt.wantIDs[i] {
+				t.Errorf("%q: record %d is %v, want %d", tt.query, i, rec["id"], tt.wantIDs[i])
+			}
+		}
+		if w.Header().Get("X-Total-Count") != "5" {
+			t.Errorf("%q: X-Total-Count %q, want 5", tt.query, w.Header().Get("X-Total-Count"))
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Connection tracking (LOG_CONNECTIONS=true) logs when connections open and
+// close and breaks each request's time down into waiting for the client,
+// reading headers, reading the body and running the handler. The time spent
+// writing the response is measured on the connection, where net/http's
+// buffer is flushed after the handler returned, and logged once the response
+// is finished. It shows clients that open a connection per request or stall
+// while uploading or downloading. The main listeners serve plain HTTP (TLS is
+// terminated in front of the server), so there is no handshake to time.
+
+// connSeq numbers the connections of the main listener.
+var connSeq int64
+
+// connInfo is what is known about one connection. Times are wall-clock
+// times, not the mock clock.
+type connInfo struct {
+	id       int64
+	listener string
+	remote   string
+	opened   time.Time
+
+	mu        sync.Mutex
+	requests  int
+	idleSince time.Time // when the last response was finished
+	waiting   bool      // no byte of the next request was read yet
+	firstByte time.Time // first byte of the request being read
+	current   connRequest
+	writing   time.Duration // spent writing to the connection for the current request
+}
+
+// connRequest is the connection side of one request's timing.
+type connRequest struct {
+	seq        int           // 1 for the first request on the connection
+	idleBefore time.Duration // keep-alive idle time before the request started
+	headerRead time.Duration // from the first byte to the end of the headers
+}
+
+// timingListener hands out connections that note when requests start arriving.
+type timingListener struct {
+	net.Listener
+	name string
+}
+
+func (l timingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	info := &connInfo{
+		id:        atomic.AddInt64(&connSeq, 1),
+		listener:  l.name,
+		remote:    conn.RemoteAddr().String(),
+		opened:    now,
+		idleSince: now,
+		waiting:   true,
+	}
+	return &timedConn{Conn: conn, info: info}, nil
+}
+
+type timedConn struct {
+	net.Conn
+	info *connInfo
+}
+
+func (c *timedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.info.mu.Lock()
+		if c.info.waiting {
+			c.info.waiting, c.info.firstByte = false, time.Now()
+		}
+		c.info.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *timedConn) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := c.Conn.Write(p)
+	c.info.mu.Lock()
+	c.info.writing += time.Since(start)
+	c.info.mu.Unlock()
+	return n, err
+}
+
+// unwrapConn returns the connection a wrapper of the main listener wraps,
+// or nil for a plain connection.
+func unwrapConn(c net.Conn) net.Conn {
+	switch v := c.(type) {
+	case *captureConn:
+		return v.Conn
+	case *timedConn:
+		return v.Conn
+	case *proxyConn:
+		return v.Conn
+	}
+	return nil
+}
+
+// connInfoOf finds the tracking of c below the other connection wrappers.
+func connInfoOf(c net.Conn) *connInfo {
+	for c != nil {
+		if tc, ok := c.(*timedConn); ok {
+			return tc.info
+		}
+		c = unwrapConn(c)
+	}
+	return nil
+}
+
+type connInfoKey struct{}
+
+// connInfoContext makes the connection's tracking reachable from its requests.
+func connInfoContext(ctx context.Context, c net.Conn) context.Context {
+	if info := connInfoOf(c); info != nil {
+		return context.WithValue(ctx, connInfoKey{}, info)
+	}
+	return ctx
+}
+
+func connInfoFrom(r *http.Request) *connInfo {
+	info, _ := r.Context().Value(connInfoKey{}).(*connInfo)
+	return info
+}
+
+// connState follows the connection through net/http's states: it becomes
+// active once a request's headers are read and idle once the response is
+// finished.
+func connState(c net.Conn, state http.ConnState) {
+	info := connInfoOf(c)
+	if info == nil {
+		return
+	}
+	now := time.Now()
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		log.Printf("Connection #%d opened on %s from %s", info.id, info.listener, info.remote)
+	case http.StateActive:
+		info.requests++
+		req := connRequest{seq: info.requests}
+		start := info.firstByte
+		if info.waiting || start.Before(info.idleSince) {
+			// The request was read ahead with the previous one
+			start = info.idleSince
+		}
+		if info.requests > 1 {
+			req.idleBefore = start.Sub(info.idleSince)
+		}
+		req.headerRead = now.Sub(start)
+		info.current, info.writing = req, 0
+	case http.StateIdle:
+		log.Printf("Connection #%d request %d: response written in %v", info.id, info.current.seq, info.writing)
+		info.idleSince, info.waiting = now, true
+	case http.StateHijacked:
+		log.Printf("Connection #%d hijacked after %d request(s), open %v", info.id, info.requests, now.Sub(info.opened))
+	case http.StateClosed:
+		idle := ""
+		if info.waiting && info.requests > 0 {
+			idle = ", idle " + now.Sub(info.idleSince).String() + " before closing"
+		} else if info.requests > 0 {
+			// Closed after the response without going idle (Connection: close)
+			idle = ", last response written in " + info.writing.String()
+		}
+		log.Printf("Connection #%d closed after %d request(s), open %v%s", info.id, info.requests, now.Sub(info.opened), idle)
+	}
+}
+
+// requestTiming is the time a request spent in each phase.
+type requestTiming struct {
+	Connection int64   `json:"connection"`
+	Request    int     `json:"connection_request"`
+	Reused     bool    `json:"reused"`
+	IdleMs     float64 `json:"idle_ms,omitempty"`
+	HeadersMs  float64 `json:"headers_ms"`
+	BodyMs     float64 `json:"body_ms"`
+	HandlerMs  float64 `json:"handler_ms"`
+
+	idle time.Duration
+}
+
+func newRequestTiming(info *connInfo, body, handler time.Duration) *requestTiming {
+	info.mu.Lock()
+	req := info.current
+	info.mu.Unlock()
+	return &requestTiming{
+		Connection: info.id,
+		Request:    req.seq,
+		Reused:     req.seq > 1,
+		IdleMs:     millis(req.idleBefore),
+		HeadersMs:  millis(req.headerRead),
+		BodyMs:     millis(body),
+		HandlerMs:  millis(handler),
+		idle:       req.idleBefore,
+	}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// describe is the connection line of the request log.
+func (t *requestTiming) describe() string {
+	if !t.Reused {
+		return "new connection"
+	}
+	return fmt.Sprintf("reused keep-alive connection after %v idle", t.idle)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// registerDiagnostics mounts the Go profiler and runtime statistics on the
+// admin router so they share its authentication:
+//
+//	/__admin/debug/pprof/            profile index (heap, goroutine, allocs, ...)
+//	/__admin/debug/pprof/goroutine?debug=2  full goroutine dump
+//	/__admin/debug/pprof/heap        heap profile for "go tool pprof"
+//	/__admin/debug/stats             memory, GC and buffer statistics as JSON
+//	POST /__admin/debug/gc           force a collection and return memory to the OS
+func (a *adminAPI) registerDiagnostics(admin *mux.Router) {
+	admin.HandleFunc("/debug/pprof/", pprof.Index).Methods("GET")
+	admin.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline).Methods("GET")
+	admin.HandleFunc("/debug/pprof/profile", pprof.Profile).Methods("GET")
+	admin.HandleFunc("/debug/pprof/symbol", pprof.Symbol).Methods("GET", "POST")
+	admin.HandleFunc("/debug/pprof/trace", pprof.Trace).Methods("GET")
+	admin.HandleFunc("/debug/pprof/{profile}", func(w http.ResponseWriter, r *http.Request) {
+		pprof.Handler(mux.Vars(r)["profile"]).ServeHTTP(w, r)
+	}).Methods("GET")
+
+	admin.HandleFunc("/debug/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, a.runtimeStats())
+	}).Methods("GET")
+	admin.HandleFunc("/debug/gc", func(w http.ResponseWriter, r *http.Request) {
+		debug.FreeOSMemory()
+		writeJSON(w, http.StatusOK, a.runtimeStats())
+	}).Methods("POST")
+}
+
+// runtimeStats reports memory and GC statistics next to the sizes of the
+// server's own buffers, which are the usual suspects when memory climbs.
+func (a *adminAPI) runtimeStats() map[string]interface{} {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+
+	gcStats := map[string]interface{}{
+		"num_gc":         m.NumGC,
+		"pause_total_ms": float64(m.PauseTotalNs) / 1e6,
+		"gc_cpu_percent": m.GCCPUFraction * 100,
+		"next_gc_bytes":  m.NextGC,
+	}
+	if len(gc.Pause) > 0 {
+		gcStats["last_gc"] = gc.LastGC.Format(time.RFC3339Nano)
+		gcStats["last_pause_ms"] = float64(gc.Pause[0]) / 1e6
+	}
+
+	namespaces := make([]map[string]interface{}, 0)
+	var journalEntries, journalBytes, mocks int
+	for _, ns := range a.namespaces.all() {
+		entries, capacity, bytes := ns.journal.stats()
+		journalEntries += entries
+		journalBytes += bytes
+		mocks += ns.mocks.len()
+		info := map[string]interface{}{
+			"name":             ns.name,
+			"journal_entries":  entries,
+			"journal_capacity": capacity,
+			"journal_bytes":    bytes,
+			"mocks":            ns.mocks.len(),
+		}
+		if ns.store != nil {
+			records := 0
+			for _, name := range ns.store.collectionNames() {
+				list, _ := ns.store.list(name)
+				records += len(list)
+			}
+			info["records"] = records
+		}
+		namespaces = append(namespaces, info)
+	}
+
+	queued, queueCap, dropped := a.logs.stats()
+	return map[string]interface{}{
+		"uptime_seconds": int64(time.Since(a.health.started).Seconds()),
+		"goroutines":     runtime.NumGoroutine(),
+		"memory": map[string]interface{}{
+			"heap_alloc_bytes":    m.HeapAlloc,
+			"heap_inuse_bytes":    m.HeapInuse,
+			"heap_idle_bytes":     m.HeapIdle,
+			"heap_released_bytes": m.HeapReleased,
+			"heap_objects":        m.HeapObjects,
+			"stack_inuse_bytes":   m.StackInuse,
+			"sys_bytes":           m.Sys,
+			"total_alloc_bytes":   m.TotalAlloc,
+		},
+		"gc": gcStats,
+		"buffers": map[string]interface{}{
+			"journal_entries": journalEntries,
+			"journal_bytes":   journalBytes,
+			"runtime_mocks":   mocks,
+			"audit_entries":   a.audit.len(),
+			"log_queue":       queued,
+			"log_queue_cap":   queueCap,
+			"log_dropped":     dropped,
+			"response_cache":  a.cache.stats(),
+		},
+		"namespaces": namespaces,
+	}
+}
+
+// logRuntimeStats writes a one-line summary every interval (RUNTIME_STATS_INTERVAL).
+func (a *adminAPI) logRuntimeStats(interval time.Duration) {
+	for range time.Tick(interval) {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		var journalEntries, journalBytes int
+		namespaces := a.namespaces.all()
+		for _, ns := range namespaces {
+			entries, _, bytes := ns.journal.stats()
+			journalEntries += entries
+			journalBytes += bytes
+		}
+		queued, _, dropped := a.logs.stats()
+		log.Printf("RUNTIME: goroutines=%d heap_alloc=%.1fMB heap_inuse=%.1fMB sys=%.1fMB num_gc=%d namespaces=%d journal_entries=%d journal_bytes=%d log_queue=%d log_dropped=%d",
+			runtime.NumGoroutine(), float64(m.HeapAlloc)/(1<<20), float64(m.HeapInuse)/(1<<20), float64(m.Sys)/(1<<20),
+			m.NumGC, len(namespaces), journalEntries, journalBytes, queued, dropped)
+	}
+}
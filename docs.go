@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// docsPage loads Swagger UI from DOCS_ASSETS_URL and points it at the spec
+// served next to it, so "try it out" calls go to this mock.
+var docsPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{.Title}}</title>
+  <link rel="stylesheet" href="{{.Assets}}/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="{{.Assets}}/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({
+      url: {{.SpecURL}},
+      dom_id: "#swagger-ui",
+      deepLinking: true,
+      tryItOutEnabled: true,
+      displayRequestDuration: true
+    });
+  </script>
+</body>
+</html>
+`))
+
+// specServerURL is the base URL the client used to reach this listener,
+// including the /__ns/{name} prefix when the spec was requested through one.
+// X-Forwarded-Proto and X-Forwarded-Host are only honoured from TRUSTED_PROXIES,
+// since any client can send them.
+func specServerURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if proxies.containsAddr(r.RemoteAddr) {
+		if proto := nearestForwarded(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwd := nearestForwarded(r.Header.Get("X-Forwarded-Host")); fwd != "" {
+			host = fwd
+		}
+	}
+	base := scheme + "://" + host
+	if ns := namespaceFrom(r); ns != nil && ns.name != "" && strings.HasPrefix(r.RequestURI, namespacePathPrefix+ns.name+"/") {
+		base += namespacePathPrefix + ns.name
+	}
+	return base
+}
+
+// nearestForwarded is the value the nearest proxy appended to a
+// comma-separated forwarding header.
+func nearestForwarded(value string) string {
+	if i := strings.LastIndexByte(value, ','); i >= 0 {
+		value = value[i+1:]
+	}
+	return strings.TrimSpace(value)
+}
+
+func specServers(url string) []interface{} {
+	return []interface{}{map[string]interface{}{"url": url, "description": "This mock server"}}
+}
+
+// handleYAML serves the spec as written, with servers replaced by this
+// listener. Converted or bundled specs are served in their final form.
+func (s *openAPISpec) handleYAML(w http.ResponseWriter, r *http.Request) {
+	var out interface{} = s.withServers(r)
+	if s.root != nil {
+		var servers yaml.Node
+		if err := servers.Encode(specServers(specServerURL(r) + s.basePath)); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "Internal Server Error", err.Error())
+			return
+		}
+		// Copy the top-level mapping so concurrent requests never share the rewrite
+		top := *s.root.Content[0]
+		top.Content = append([]*yaml.Node(nil), top.Content...)
+		replaced := false
+		for i := 0; i+1 < len(top.Content); i += 2 {
+			if top.Content[i].Value == "servers" {
+				top.Content[i+1] = &servers
+				replaced = true
+			}
+		}
+		if !replaced {
+			top.Content = append(top.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "servers"}, &servers)
+		}
+		out = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&top}}
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(out); err != nil {
+		log.Printf("Error writing %s: %v", s.file, err)
+	}
+	enc.Close()
+}
+
+// handleJSON serves the spec as JSON, with servers replaced by this listener.
+func (s *openAPISpec) handleJSON(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.withServers(r))
+}
+
+// withServers is a shallow copy of the document with servers pointing at this listener.
+func (s *openAPISpec) withServers(r *http.Request) map[string]interface{} {
+	doc := make(map[string]interface{}, len(s.doc)+1)
+	for k, v := range s.doc {
+		doc[k] = v
+	}
+	doc["servers"] = specServers(specServerURL(r) + s.basePath)
+	return doc
+}
+
+// handleDocs serves the interactive documentation page.
+func (s *openAPISpec) handleDocs(assets string) http.HandlerFunc {
+	title := "API documentation"
+	if info, ok := s.doc["info"].(map[string]interface{}); ok {
+		if t, ok := info["title"].(string); ok && t != "" {
+			title = t
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The security header preset forbids scripts; the page needs its own and the UI assets
+		if w.Header().Get("Content-Security-Policy") != "" {
+			w.Header().Set("Content-Security-Policy", fmt.Sprintf("default-src 'self' %[1]s; script-src 'self' 'unsafe-inline' %[1]s; style-src 'self' 'unsafe-inline' %[1]s; img-src 'self' data: %[1]s", assetsOrigin(assets)))
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := docsPage.Execute(w, map[string]string{
+			"Title":   title,
+			"Assets":  strings.TrimSuffix(assets, "/"),
+			"SpecURL": "openapi.json",
+		})
+		if err != nil {
+			log.Printf("Error rendering docs page: %v", err)
+		}
+	}
+}
+
+// assetsOrigin is the scheme and host of the assets URL, for the page's CSP.
+func assetsOrigin(assets string) string {
+	u, err := url.Parse(assets)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
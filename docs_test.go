@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpecServerURL(t *testing.T) {
+	trusted, err := parseIPNetworks("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(p ipNetworks) { proxies = p }(proxies)
+
+	tests := []struct {
+		name    string
+		proxies ipNetworks
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"no forwarding headers", trusted, "10.0.0.1:5000", nil, "http://example.com"},
+		{"trusted proxy", trusted, "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "api.example.org"}, "https://api.example.org"},
+		{"untrusted peer", trusted, "192.0.2.9:5000",
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example"}, "http://example.com"},
+		{"no trusted proxies", nil, "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example"}, "http://example.com"},
+		{"nearest proxy's values", trusted, "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-Proto": "http, https", "X-Forwarded-Host": "evil.example, api.example.org"}, "https://api.example.org"},
+		{"unknown scheme", trusted, "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-Proto": "javascript"}, "http://example.com"},
+	}
+	for _, tt := range tests {
+		proxies = tt.proxies
+		r := httptest.NewRequest("GET", "http://example.com/openapi.yaml", nil)
+		r.RemoteAddr = tt.remote
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got := specServerURL(r); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
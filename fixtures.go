@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadFixtures adds the records of every <collection>.csv, .yaml, .yml or .json
+// file in dir to the store. Files for collections not declared in the store
+// configuration create a new collection.
+func (s *store) loadFixtures(dir string) (map[string]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loaded := make(map[string]int)
+	for _, file := range names {
+		ext := strings.ToLower(filepath.Ext(file))
+		path := filepath.Join(dir, file)
+		var records []record
+		switch ext {
+		case ".csv":
+			records, err = readCSVFixture(path)
+		case ".yaml", ".yml":
+			records, err = readYAMLFixture(path)
+		case ".json":
+			records, err = readSeedFile(path)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fixture %s: %w", path, err)
+		}
+
+		name := strings.TrimSuffix(file, filepath.Ext(file))
+		c := s.collections[name]
+		if c == nil {
+			c = newCollection(name)
+			s.collections[name] = c
+		}
+		for _, rec := range records {
+			c.insert(rec)
+		}
+		loaded[name] += len(records)
+	}
+	return loaded, nil
+}
+
+// readCSVFixture reads a CSV file with a header row. Cells are typed the way
+// JSON would type them: numbers, true/false, null, and JSON arrays or objects
+// (e.g. ["prod-001","prod-002"]) are decoded; empty cells are omitted.
+func readCSVFixture(path string) ([]record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	header := rows[0]
+	records := make([]record, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		rec := make(record, len(header))
+		for i, col := range header {
+			if i >= len(row) || row[i] == "" {
+				continue
+			}
+			rec[col] = csvValue(row[i])
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func csvValue(cell string) interface{} {
+	switch cell {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if n, err := strconv.ParseFloat(cell, 64); err == nil {
+		return n
+	}
+	if strings.HasPrefix(cell, "[") || strings.HasPrefix(cell, "{") {
+		var v interface{}
+		if err := json.Unmarshal([]byte(cell), &v); err == nil {
+			return v
+		}
+	}
+	return cell
+}
+
+// readYAMLFixture reads a YAML list of mappings or a single mapping.
+func readYAMLFixture(path string) ([]record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	switch v := jsonCompatible(doc).(type) {
+	case []interface{}:
+		records := make([]record, 0, len(v))
+		for i, item := range v {
+			rec, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("item %d is not a mapping", i)
+			}
+			records = append(records, rec)
+		}
+		return records, nil
+	case map[string]interface{}:
+		return []record{v}, nil
+	}
+	return nil, fmt.Errorf("%s is neither a mapping nor a list of mappings", path)
+}
+
+// jsonCompatible converts decoded YAML into the types encoding/json produces
+// (string map keys, float64 numbers, RFC 3339 strings for timestamps) so YAML
+// records behave exactly like JSON ones.
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = jsonCompatible(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[fmt.Sprint(k)] = jsonCompatible(item)
+		}
+		return out
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonCompatible(item)
+		}
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case interface{ MarshalText() ([]byte, error) }:
+		text, err := v.MarshalText()
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(text)
+	}
+	return v
+}
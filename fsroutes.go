@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Response files are routed by convention: the directory is the URL path and
+// the file name the method, optional status and any extension, e.g.
+//
+//	responses/users/GET.json            GET  /users
+//	responses/users/POST.201.json       POST /users -> 201
+//	responses/users/{id}/GET.json       GET  /users/{id}
+//	responses/users/{id}/avatar/GET.png GET  /users/{id}/avatar as image/png
+//	responses/users/{id}/GET.meta.json  sidecar: {"headers": {...}, "delay_ms": 200}
+var routeFilePattern = regexp.MustCompile(`^(GET|POST|PUT|PATCH|DELETE|HEAD|OPTIONS)(?:\.(\d{3}))?\.([A-Za-z0-9]+)$`)
+
+const routeMetaSuffix = ".meta.json"
+
+// fileRoute is one response file discovered in the response layers.
+type fileRoute struct {
+	Method string
+	Path   string // "{name}" segments match any value
+	Status int
+	File   string
+	Layer  string
+	Meta   routeMeta
+}
+
+// routeMeta is the optional sidecar of a response file.
+type routeMeta struct {
+	Headers map[string]string `json:"headers,omitempty"`
+	DelayMs int               `json:"delay_ms,omitempty"`
+	// ContentType overrides the type derived from the extension or content.
+	ContentType string `json:"content_type,omitempty"`
+	// Download adds Content-Disposition: attachment, named Filename or after
+	// the last path segment.
+	Download bool   `json:"download,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// fileRouter serves the routes found in a stack of response layers. Requests
+// may pick the overlays stacked on the base layer with the layer header. The
+// layers are rescanned in the background at most every checkEvery, so adding
+// a file adds an endpoint without a restart.
+type fileRouter struct {
+	layers     []responseLayer
+	header     string
+	files      *responseCache
+	checkEvery time.Duration
+
+	mu     sync.Mutex
+	tables map[string]*routeTable // keyed by the selected layer names
+}
+
+type routeTable struct {
+	routes   []fileRoute
+	err      error // from the last scan
+	scanned  time.Time
+	scanning bool
+}
+
+func newFileRouter(layers []responseLayer, header string, files *responseCache, checkEvery time.Duration) *fileRouter {
+	fr := &fileRouter{layers: layers, header: header, files: files, checkEvery: checkEvery, tables: make(map[string]*routeTable)}
+	fr.table(layers)
+	return fr
+}
+
+// layersFor returns the base layer with the overlay selected by the caller's
+// client profile or the overlays in the request's layer header, or all of them.
+func (fr *fileRouter) layersFor(r *http.Request) []responseLayer {
+	if m := clientProfileFrom(r); m != nil && m.profile.Layer != "" {
+		selected, _ := selectLayers(fr.layers, []string{m.profile.Layer})
+		return selected
+	}
+	value := r.Header.Get(fr.header)
+	if value == "" {
+		return fr.layers
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	selected, unknown := selectLayers(fr.layers, names)
+	if len(unknown) > 0 {
+		log.Printf("Ignoring unknown response layer(s) %s in %s", strings.Join(unknown, ", "), fr.header)
+	}
+	return selected
+}
+
+// table returns the routes of a layer stack. A stack seen for the first time
+// is scanned before returning; a stale one keeps serving its routes while it
+// is rescanned in the background. The lock is never held while scanning.
+func (fr *fileRouter) table(layers []responseLayer) []fileRoute {
+	key := strings.Join(layerNames(layers), ",")
+	fr.mu.Lock()
+	t := fr.tables[key]
+	if t == nil {
+		fr.mu.Unlock()
+		return fr.rescan(key, layers)
+	}
+	routes := t.routes
+	if !t.scanning && time.Since(t.scanned) >= fr.checkEvery {
+		t.scanning = true
+		go fr.rescan(key, layers)
+	}
+	fr.mu.Unlock()
+	return routes
+}
+
+// rescan scans a layer stack and replaces its routes.
+func (fr *fileRouter) rescan(key string, layers []responseLayer) []fileRoute {
+	routes, err := scanRouteFiles(layers)
+	if err != nil {
+		log.Printf("Failed to scan response files: %v", err)
+	}
+	fr.mu.Lock()
+	fr.tables[key] = &routeTable{routes: routes, err: err, scanned: time.Now()}
+	fr.mu.Unlock()
+	return routes
+}
+
+// check reports the last scan of the full layer stack failing, e.g. because
+// a response directory went missing.
+func (fr *fileRouter) check() error {
+	fr.table(fr.layers)
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if t := fr.tables[strings.Join(layerNames(fr.layers), ",")]; t != nil {
+		return t.err
+	}
+	return nil
+}
+
+// scanRouteFiles merges the layers and returns their routes, most specific first.
+func scanRouteFiles(layers []responseLayer) ([]fileRoute, error) {
+	merged, err := mergeLayers(layers)
+	routes := make([]fileRoute, 0)
+	for rel, lf := range merged {
+		m := routeFilePattern.FindStringSubmatch(path.Base(rel))
+		if m == nil {
+			continue
+		}
+		dir := path.Dir(rel)
+		route := fileRoute{Method: m[1], Path: "/" + dir, File: lf.Path, Layer: lf.Layer}
+		if dir == "." {
+			route.Path = "/"
+		}
+		route.Status = defaultRouteStatus(route.Method)
+		if m[2] != "" {
+			route.Status, _ = strconv.Atoi(m[2])
+		}
+		if meta, ok := merged[path.Join(dir, route.Method+routeMetaSuffix)]; ok {
+			if data, err := os.ReadFile(meta.Path); err != nil {
+				log.Printf("Ignoring %s: %v", meta.Path, err)
+			} else if err := json.Unmarshal(data, &route.Meta); err != nil {
+				log.Printf("Ignoring %s: %v", meta.Path, err)
+			}
+		}
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if c := comparePathSpecificity(routes[i].Path, routes[j].Path); c != 0 {
+			return c < 0
+		}
+		if routes[i].Method != routes[j].Method {
+			return routes[i].Method < routes[j].Method
+		}
+		if routes[i].Status != routes[j].Status {
+			return routes[i].Status < routes[j].Status
+		}
+		// JSON is the default representation when the client accepts anything
+		ei, ej := filepath.Ext(routes[i].File), filepath.Ext(routes[j].File)
+		if (ei == ".json") != (ej == ".json") {
+			return ei == ".json"
+		}
+		return routes[i].File < routes[j].File
+	})
+	return routes, err
+}
+
+// defaultRouteStatus keeps the status the hand-wired routes used: 201 for POST, 200 otherwise.
+func defaultRouteStatus(method string) int {
+	if method == http.MethodPost {
+		return http.StatusCreated
+	}
+	return http.StatusOK
+}
+
+// comparePathSpecificity orders paths so literal segments win over "{name}"
+// segments, then alphabetically.
+func comparePathSpecificity(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		ap, bp := strings.HasPrefix(as[i], "{"), strings.HasPrefix(bs[i], "{")
+		if ap != bp {
+			if bp {
+				return -1
+			}
+			return 1
+		}
+		if as[i] != bs[i] {
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// lookup returns the route serving r. With several status files for the same
+// method and path the lowest status wins; with several file types (GET.json,
+// GET.csv) the first one accepted by the Accept header wins.
+func (fr *fileRouter) lookup(r *http.Request) *fileRoute {
+	var found *fileRoute
+	routes := fr.table(fr.layersFor(r))
+	for i := range routes {
+		route := &routes[i]
+		if route.Method != r.Method || !pathMatches(route.Path, r.URL.Path) {
+			continue
+		}
+		if found == nil {
+			found = route
+		} else if route.Path != found.Path || route.Status != found.Status {
+			break
+		}
+		if accepts(r.Header.Get("Accept"), route.contentType(nil)) {
+			return route
+		}
+	}
+	return found
+}
+
+// contentType is the sidecar override, the type registered for the extension,
+// or the type sniffed from data.
+func (route *fileRoute) contentType(data []byte) string {
+	if route.Meta.ContentType != "" {
+		return route.Meta.ContentType
+	}
+	ext := strings.ToLower(filepath.Ext(route.File))
+	if ct := extraContentTypes[ext]; ct != "" {
+		return ct
+	}
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+	if data == nil {
+		return ""
+	}
+	return http.DetectContentType(data)
+}
+
+// extraContentTypes covers common mock payloads missing from minimal mime tables.
+var extraContentTypes = map[string]string{
+	".json": "application/json",
+	".csv":  "text/csv; charset=utf-8",
+	".txt":  "text/plain; charset=utf-8",
+	".yaml": "application/yaml",
+	".yml":  "application/yaml",
+	".zip":  "application/zip",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".ics":  "text/calendar; charset=utf-8",
+}
+
+// accepts reports whether an Accept header allows contentType. An empty
+// header, an unknown type and */* accept everything.
+func accepts(accept, contentType string) bool {
+	if accept == "" || contentType == "" {
+		return true
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, part := range strings.Split(accept, ",") {
+		want, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if want == "*/*" || want == mediaType || want == major+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+type responseLayerKey struct{}
+
+// recordLayer notes the response layer that answered r for the request's log
+// and journal records.
+func recordLayer(r *http.Request, layer string) {
+	if l, ok := r.Context().Value(responseLayerKey{}).(*string); ok {
+		*l = layer
+	}
+}
+
+type fileRouteKey struct{}
+
+// match is a mux matcher so the router can sit before the catch-all route.
+// The route it found is handed to serve in the request context, so each
+// request is looked up once.
+func (fr *fileRouter) match(r *http.Request, m *mux.RouteMatch) bool {
+	route := fr.lookup(r)
+	if route == nil {
+		return false
+	}
+	m.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fr.serve(w, r.WithContext(context.WithValue(r.Context(), fileRouteKey{}, route)))
+	})
+	return true
+}
+
+func (fr *fileRouter) serve(w http.ResponseWriter, r *http.Request) {
+	route, _ := r.Context().Value(fileRouteKey{}).(*fileRoute)
+	if route == nil {
+		route = fr.lookup(r)
+	}
+	if route == nil {
+		catchAllHandler(w, r)
+		return
+	}
+	data, err := fr.files.get(route.File)
+	if err != nil {
+		log.Printf("Error reading file %s: %v", route.File, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to read response file",
+			"file":  route.File,
+		})
+		return
+	}
+	if len(fr.layers) > 1 {
+		recordLayer(r, route.Layer)
+	}
+	if route.Meta.DelayMs > 0 && !wait(r, time.Duration(route.Meta.DelayMs)*time.Millisecond) {
+		abandon(w, r)
+		return
+	}
+	if len(data) > 0 {
+		w.Header().Set("Content-Type", route.contentType(data))
+	}
+	if route.Meta.Download {
+		name := route.Meta.Filename
+		if name == "" {
+			name = path.Base(r.URL.Path)
+			if path.Ext(name) == "" {
+				name += filepath.Ext(route.File)
+			}
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	}
+	for k, v := range route.Meta.Headers {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(route.Status)
+	w.Write(data)
+}
+
+// logTable prints the routes of the full layer stack in place of a
+// hand-written endpoint list.
+func (fr *fileRouter) logTable() {
+	routes := fr.table(fr.layers)
+	if len(routes) == 0 {
+		log.Printf("  (no response files found in %s)", strings.Join(layerNames(fr.layers), ", "))
+	}
+	for _, route := range routes {
+		extra := ""
+		if len(fr.layers) > 1 {
+			extra = ", layer " + route.Layer
+		}
+		if route.Meta.DelayMs > 0 {
+			extra += fmt.Sprintf(", %dms delay", route.Meta.DelayMs)
+		}
+		log.Printf("  %-6s %s -> %s (%d%s)", route.Method, route.Path, filepath.ToSlash(route.File), route.Status, extra)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// writeResponseFiles creates files (relative path -> content) under a new
+// temporary directory and returns it.
+func writeResponseFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for rel, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestFileRouterLookupAcceptFallback(t *testing.T) {
+	dir := writeResponseFiles(t, map[string]string{
+		"users/GET.json":      `[]`,
+		"users/GET.csv":       "id\n",
+		"users/POST.json":     `{}`,
+		"users/{id}/GET.json": `{}`,
+		"users/{id}/PUT.json": `{}`,
+	})
+	fr := newFileRouter([]responseLayer{{Name: "base", Dir: dir}}, "X-Mock-Layer", nil, time.Hour)
+
+	tests := []struct {
+		method, path, accept string
+		file                 string
+		status               int
+	}{
+		{"GET", "/users", "", "users/GET.json", 200},
+		{"GET", "/users", "text/csv", "users/GET.csv", 200},
+		// Nothing acceptable: the first representation of the matching route
+		{"GET", "/users", "application/xml", "users/GET.json", 200},
+		{"POST", "/users", "text/html", "users/POST.json", 201},
+		{"PUT", "/users/7", "application/xml", "users/{id}/PUT.json", 200},
+		{"DELETE", "/users/7", "", "", 0},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		route := fr.lookup(r)
+		if tt.file == "" {
+			if route != nil {
+				t.Errorf("%s %s: got %s, want no route", tt.method, tt.path, route.File)
+			}
+			continue
+		}
+		if route == nil {
+			t.Errorf("%s %s (Accept %q): no route, want %s", tt.method, tt.path, tt.accept, tt.file)
+			continue
+		}
+		if want := filepath.Join(dir, filepath.FromSlash(tt.file)); route.File != want || route.Status != tt.status {
+			t.Errorf("%s %s (Accept %q): got %s %d, want %s %d", tt.method, tt.path, tt.accept, route.File, route.Status, want, tt.status)
+		}
+	}
+}
+
+func TestFileRouterLayerHeaderStacksOnBase(t *testing.T) {
+	base := writeResponseFiles(t, map[string]string{
+		"users/GET.json":    `["base"]`,
+		"products/GET.json": `["base"]`,
+	})
+	staging := writeResponseFiles(t, map[string]string{
+		"users/GET.json": `["staging"]`,
+	})
+	layers := []responseLayer{{Name: "base", Dir: base}, {Name: "staging", Dir: staging}}
+	fr := newFileRouter(layers, "X-Mock-Layer", nil, time.Hour)
+
+	tests := []struct {
+		path, layer, wantLayer string
+	}{
+		{"/users", "", "staging"},
+		{"/users", "staging", "staging"},
+		{"/products", "staging", "base"},
+		{"/users", "base", "base"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		r.Header.Set("X-Mock-Layer", tt.layer)
+		route := fr.lookup(r)
+		if route == nil {
+			t.Errorf("%s with layer %q: no route, want layer %s", tt.path, tt.layer, tt.wantLayer)
+		} else if route.Layer != tt.wantLayer {
+			t.Errorf("%s with layer %q: served from %s, want %s", tt.path, tt.layer, route.Layer, tt.wantLayer)
+		}
+	}
+}
+
+func TestFileRouterProfileLayerStacksOnBase(t *testing.T) {
+	base := writeResponseFiles(t, map[string]string{
+		"users/GET.json":    `["base"]`,
+		"products/GET.json": `["base"]`,
+	})
+	acme := writeResponseFiles(t, map[string]string{
+		"users/GET.json": `["acme"]`,
+	})
+	globex := writeResponseFiles(t, map[string]string{
+		"users/GET.json": `["globex"]`,
+	})
+	layers := []responseLayer{{Name: "base", Dir: base}, {Name: "acme", Dir: acme}, {Name: "globex", Dir: globex}}
+	fr := newFileRouter(layers, "X-Mock-Layer", nil, time.Hour)
+
+	tests := []struct {
+		path, profileLayer, wantLayer string
+	}{
+		{"/users", "acme", "acme"},
+		{"/products", "acme", "base"},
+		{"/users", "globex", "globex"},
+		{"/products", "globex", "base"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		m := &clientMatchResult{profile: &clientProfile{Name: "partner", Layer: tt.profileLayer}}
+		r = r.WithContext(context.WithValue(r.Context(), clientProfileKey{}, m))
+		route := fr.lookup(r)
+		if route == nil {
+			t.Errorf("%s with profile layer %q: no route, want layer %s", tt.path, tt.profileLayer, tt.wantLayer)
+		} else if route.Layer != tt.wantLayer {
+			t.Errorf("%s with profile layer %q: served from %s, want %s", tt.path, tt.profileLayer, route.Layer, tt.wantLayer)
+		}
+	}
+}
+
+func TestFileRouterMatchHandsRouteToServe(t *testing.T) {
+	dir := writeResponseFiles(t, map[string]string{
+		"users/GET.json":      `["list"]`,
+		"users/{id}/GET.json": `{"one":true}`,
+	})
+	fr := newFileRouter([]responseLayer{{Name: "base", Dir: dir}}, "X-Mock-Layer", newResponseCache(false, 0, 0), time.Hour)
+
+	r := httptest.NewRequest("GET", "/users/7", nil)
+	m := &mux.RouteMatch{}
+	if !fr.match(r, m) || m.Handler == nil {
+		t.Fatal("GET /users/7: not matched")
+	}
+	// Served from the matched route even though the request changed since
+	r.URL.Path = "/users"
+	w := httptest.NewRecorder()
+	m.Handler.ServeHTTP(w, r)
+	if got := w.Body.String(); got != `{"one":true}` {
+		t.Errorf("got %s, want the matched route's body", got)
+	}
+	if fr.match(httptest.NewRequest("DELETE", "/users/7", nil), &mux.RouteMatch{}) {
+		t.Error("DELETE /users/7: matched, want no route")
+	}
+}
+
+func TestFileRouterRescansInBackground(t *testing.T) {
+	dir := writeResponseFiles(t, map[string]string{"users/GET.json": `[]`})
+	fr := newFileRouter([]responseLayer{{Name: "base", Dir: dir}}, "X-Mock-Layer", nil, 0)
+	if err := os.MkdirAll(filepath.Join(dir, "orders"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "orders", "GET.json"), []byte(`[]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for fr.lookup(httptest.NewRequest("GET", "/orders", nil)) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("GET /orders: new response file never picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		accept, contentType string
+		want                bool
+	}{
+		{"", "application/json", true},
+		{"text/csv", "", true},
+		{"*/*", "image/png", true},
+		{"application/json", "application/json; charset=utf-8", true},
+		{"text/html, application/json;q=0.9", "application/json", true},
+		{"image/*", "image/png", true},
+		{"image/*", "text/png", false},
+		{"text/csv", "application/json", false},
+		{"application/json-seq", "application/json", false},
+		{" text/csv ;q=1 , text/plain", "text/plain", true},
+	}
+	for _, tt := range tests {
+		if got := accepts(tt.accept, tt.contentType); got != tt.want {
+			t.Errorf("accepts(%q, %q) = %v, want %v", tt.accept, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestFileRouterRecordsServedLayer(t *testing.T) {
+	base := writeResponseFiles(t, map[string]string{"users/GET.json": `[]`, "products/GET.json": `[]`})
+	staging := writeResponseFiles(t, map[string]string{"users/GET.json": `["staging"]`})
+	fr := newFileRouter([]responseLayer{{Name: "base", Dir: base}, {Name: "staging", Dir: staging}}, "X-Mock-Layer", newResponseCache(false, 0, 0), time.Hour)
+
+	for path, want := range map[string]string{"/users": "staging", "/products": "base"} {
+		var layer string
+		r := httptest.NewRequest("GET", path, nil)
+		r = r.WithContext(context.WithValue(r.Context(), responseLayerKey{}, &layer))
+		fr.serve(httptest.NewRecorder(), r)
+		if layer != want {
+			t.Errorf("%s: recorded layer %q, want %q", path, layer, want)
+		}
+	}
+}
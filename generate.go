@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	fakeFirstNames = []string{"James", "Mary", "Robert", "Patricia", "John", "Jennifer", "Michael", "Linda", "David", "Elizabeth",
+		"William", "Barbara", "Richard", "Susan", "Joseph", "Jessica", "Thomas", "Sarah", "Daniel", "Karen", "Noa", "Yosef", "Maya", "Ari"}
+	fakeLastNames = []string{"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez", "Martinez",
+		"Hernandez", "Lopez", "Wilson", "Anderson", "Taylor", "Moore", "Jackson", "Martin", "Lee", "Cohen", "Levi", "Katz"}
+	fakeAdjectives = []string{"Wireless", "Ergonomic", "Compact", "Portable", "Smart", "Classic", "Premium", "Durable", "Lightweight",
+		"Ceramic", "Stainless", "Vintage", "Modern", "Foldable", "Rechargeable"}
+	fakeNouns = []string{"Keyboard", "Mouse", "Monitor", "Headphones", "Speaker", "Lamp", "Backpack", "Bottle", "Mug", "Chair",
+		"Desk", "Charger", "Camera", "Notebook", "Watch"}
+	fakeWords = []string{"reliable", "everyday", "use", "with", "long", "battery", "life", "designed", "for", "work", "and", "travel",
+		"easy", "to", "clean", "high", "performance", "quality", "materials", "comfortable", "grip", "fast", "setup", "gift"}
+	fakeCities    = []string{"London", "New York", "Berlin", "Tel Aviv", "Paris", "Madrid", "Toronto", "Sydney", "Tokyo", "Amsterdam"}
+	fakeCountries = []string{"UK", "USA", "Germany", "Israel", "France", "Spain", "Canada", "Australia", "Japan", "Netherlands"}
+	fakeStreets   = []string{"Main St", "High St", "Park Ave", "Oak St", "Maple Ave", "Cedar Rd", "Elm St", "Lake Dr"}
+)
+
+// generator produces fake records shaped like the records already in a
+// collection. The same seed always yields the same data.
+type generator struct {
+	s   *store
+	rnd *rand.Rand
+	seq int
+}
+
+// generate adds counts[name] fake records to each collection. Collections are
+// filled targets-first so relation fields only point at existing records.
+func (s *store) generate(counts map[string]int, seed int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := &generator{s: s, rnd: rand.New(rand.NewSource(seed))}
+	for _, name := range s.generationOrderLocked(counts) {
+		n := counts[name]
+		c := s.collections[name]
+		if c == nil {
+			return fmt.Errorf("unknown collection %q", name)
+		}
+		templates := c.all()
+		if len(templates) == 0 {
+			return fmt.Errorf("collection %q has no seed record to use as a template", name)
+		}
+		shape := g.shapeOf(name, templates)
+		for i := 0; i < n; i++ {
+			c.insert(g.record(name, shape))
+		}
+	}
+	return nil
+}
+
+// generationOrderLocked sorts the requested collections so relation targets come first.
+func (s *store) generationOrderLocked(counts map[string]int) []string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var order []string
+	visited := make(map[string]bool)
+	var visit func(string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		for _, rel := range s.relations {
+			if rel.Collection == name && counts[rel.Target] > 0 {
+				visit(rel.Target)
+			}
+		}
+		order = append(order, name)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return order
+}
+
+// fieldShape describes one field of the template records.
+type fieldShape struct {
+	name    string
+	sample  interface{}
+	choices []interface{} // distinct template values when the field looks like an enum
+	rel     *relation
+}
+
+func (g *generator) shapeOf(name string, templates []record) []fieldShape {
+	keys := make(map[string]bool)
+	for _, t := range templates {
+		for k := range t {
+			keys[k] = true
+		}
+	}
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		if k != "id" {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	shape := make([]fieldShape, 0, len(names))
+	for _, field := range names {
+		fs := fieldShape{name: field}
+		seen := make(map[string]bool)
+		present := 0
+		for _, t := range templates {
+			v, ok := t[field]
+			if !ok {
+				continue
+			}
+			present++
+			if fs.sample == nil {
+				fs.sample = v
+			}
+			if s, isString := v.(string); isString && !seen[s] {
+				seen[s] = true
+				fs.choices = append(fs.choices, s)
+			}
+		}
+		if rel, ok := g.s.relationFor(name, field); ok {
+			fs.rel = &rel
+		}
+		if cr := g.s.rules[name]; cr != nil && cr.Status != nil && cr.Status.Field == field {
+			for from, to := range cr.Status.Transitions {
+				for _, status := range append([]string{from}, to...) {
+					if !seen[status] {
+						seen[status] = true
+						fs.choices = append(fs.choices, status)
+					}
+				}
+			}
+			sort.Slice(fs.choices, func(i, j int) bool { return fs.choices[i].(string) < fs.choices[j].(string) })
+		}
+		if !looksLikeEnum(field, len(fs.choices), present) {
+			fs.choices = nil
+		}
+		shape = append(shape, fs)
+	}
+	return shape
+}
+
+func looksLikeEnum(field string, distinct, total int) bool {
+	if distinct == 0 {
+		return false
+	}
+	switch strings.ToLower(field) {
+	case "status", "state", "category", "type", "kind", "role", "currency", "country":
+		return true
+	}
+	return distinct < total
+}
+
+func (g *generator) record(name string, shape []fieldShape) record {
+	g.seq++
+	first := g.pick(fakeFirstNames)
+	last := g.pick(fakeLastNames)
+	_, person := findField(shape, "email")
+
+	rec := make(record, len(shape)+1)
+	for _, fs := range shape {
+		switch {
+		case fs.rel != nil:
+			rec[fs.name] = g.reference(fs)
+		case len(fs.choices) > 0:
+			rec[fs.name] = fs.choices[g.rnd.Intn(len(fs.choices))]
+		default:
+			rec[fs.name] = g.value(fs, first, last, person)
+		}
+	}
+
+	if cr := g.s.rules[name]; cr != nil && cr.Items != nil {
+		if rel, ok := g.s.relationFor(name, cr.Items.Field); ok {
+			qty, _ := itemQuantities(rec, cr.Items)
+			rec[cr.Items.Total] = itemsTotal(g.s.collections[rel.Target], qty, cr.Items)
+		}
+	}
+	return rec
+}
+
+func findField(shape []fieldShape, name string) (fieldShape, bool) {
+	for _, fs := range shape {
+		if fs.name == name {
+			return fs, true
+		}
+	}
+	return fieldShape{}, false
+}
+
+// reference picks existing target ids, keeping the template's shape
+// (single id, list of ids, or list of {"id": ..., ...} objects).
+func (g *generator) reference(fs fieldShape) interface{} {
+	target := g.s.collections[fs.rel.Target]
+	if len(target.ids) == 0 {
+		return fs.sample
+	}
+	list, isList := fs.sample.([]interface{})
+	if !isList {
+		return target.ids[g.rnd.Intn(len(target.ids))]
+	}
+
+	n := 1 + g.rnd.Intn(3)
+	if n > len(target.ids) {
+		n = len(target.ids)
+	}
+	var itemTemplate map[string]interface{}
+	if len(list) > 0 {
+		itemTemplate, _ = list[0].(map[string]interface{})
+	}
+	out := make([]interface{}, 0, n)
+	for _, i := range g.rnd.Perm(len(target.ids))[:n] {
+		id := target.ids[i]
+		if itemTemplate == nil {
+			out = append(out, id)
+			continue
+		}
+		item := map[string]interface{}{"id": id}
+		keys := make([]string, 0, len(itemTemplate))
+		for k := range itemTemplate {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if _, isNumber := itemTemplate[k].(float64); isNumber && k != "id" {
+				item[k] = float64(1 + g.rnd.Intn(3))
+			}
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+func (g *generator) value(fs fieldShape, first, last string, person bool) interface{} {
+	field := strings.ToLower(fs.name)
+	switch sample := fs.sample.(type) {
+	case string:
+		return g.text(field, sample, first, last, person)
+	case float64:
+		return g.number(field, sample)
+	case bool:
+		return g.rnd.Intn(2) == 0
+	}
+	return fs.sample
+}
+
+func (g *generator) text(field, sample, first, last string, person bool) string {
+	switch {
+	case field == "email" || strings.HasSuffix(field, "_email"):
+		return fmt.Sprintf("%s.%s%d@example.com", strings.ToLower(first), strings.ToLower(last), g.seq)
+	case field == "first_name" || field == "firstname":
+		return first
+	case field == "last_name" || field == "lastname" || field == "surname":
+		return last
+	case field == "name" || field == "full_name" || field == "username":
+		if person {
+			return first + " " + last
+		}
+		return g.pick(fakeAdjectives) + " " + g.pick(fakeNouns)
+	case field == "title":
+		return g.pick(fakeAdjectives) + " " + g.pick(fakeNouns)
+	case strings.HasSuffix(field, "_at") || strings.Contains(field, "date") || strings.Contains(field, "time"):
+		if _, err := time.Parse(time.RFC3339, sample); err == nil {
+			base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			return base.Add(time.Duration(g.rnd.Int63n(int64(365 * 24 * time.Hour)))).Truncate(time.Second).Format(time.RFC3339)
+		}
+	case strings.Contains(field, "description") || field == "bio" || field == "summary" || field == "comment":
+		return g.sentence(6 + g.rnd.Intn(6))
+	case strings.Contains(field, "phone"):
+		return fmt.Sprintf("+1-555-%03d-%04d", g.rnd.Intn(1000), g.rnd.Intn(10000))
+	case field == "city":
+		return g.pick(fakeCities)
+	case field == "country":
+		return g.pick(fakeCountries)
+	case strings.Contains(field, "address") || field == "street":
+		return fmt.Sprintf("%d %s", 1+g.rnd.Intn(999), g.pick(fakeStreets))
+	case strings.Contains(field, "url") || field == "website":
+		return fmt.Sprintf("https://example.com/%s/%d", field, g.seq)
+	}
+	return fmt.Sprintf("%s-%d", field, g.seq)
+}
+
+func (g *generator) number(field string, sample float64) float64 {
+	switch {
+	case strings.Contains(field, "price") || strings.Contains(field, "amount") || strings.Contains(field, "cost") || field == "total":
+		upper := math.Max(sample*2, 10)
+		return math.Round((1+g.rnd.Float64()*(upper-1))*100) / 100
+	case strings.Contains(field, "stock") || strings.Contains(field, "quantity") || strings.Contains(field, "count") || field == "qty":
+		return float64(g.rnd.Intn(101))
+	}
+	upper := math.Abs(sample)*2 + 10
+	if sample == math.Trunc(sample) {
+		return float64(g.rnd.Int63n(int64(upper)))
+	}
+	return math.Round(g.rnd.Float64()*upper*100) / 100
+}
+
+func (g *generator) sentence(words int) string {
+	parts := make([]string, words)
+	for i := range parts {
+		parts[i] = g.pick(fakeWords)
+	}
+	s := strings.Join(parts, " ")
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func (g *generator) pick(list []string) string {
+	return list[g.rnd.Intn(len(list))]
+}
+
+// parseGenerateCounts parses GENERATE, e.g. "users=1000,products=200,orders=5000".
+func parseGenerateCounts(spec string) (map[string]int, error) {
+	counts := make(map[string]int)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, n, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid entry %q (want collection=count)", part)
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("invalid count in %q", part)
+		}
+		counts[strings.TrimSpace(name)] = count
+	}
+	return counts, nil
+}
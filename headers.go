@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// headerConfig is the set of headers added to every response of the main
+// listener, read from HEADERS_FILE:
+//
+//	{
+//	  "defaults": {"X-Served-By": "orders-api", "X-Request-Time": "{{now}}"},
+//	  "remove": ["X-Timestamp"],
+//	  "security": true,
+//	  "server": "nginx/1.25.3",
+//	  "routes": [
+//	    {"method": "GET", "path": "/products/*", "headers": {"Cache-Control": "public, max-age=60"}},
+//	    {"path": "/users/{id}", "remove": ["X-Served-By"]}
+//	  ]
+//	}
+//
+// Defaults start from X-Served-By and X-Timestamp; "{{now}}" is replaced by
+// the mock clock's time. Headers set by a handler, mock or sidecar win over
+// these; "remove" strips headers from the final response whatever set them.
+type headerConfig struct {
+	Defaults map[string]string `json:"defaults"`
+	Remove   []string          `json:"remove"`
+	// Security adds the securityHeaders preset.
+	Security bool `json:"security"`
+	// Server impersonates a production server; Go sends no Server header by default.
+	Server string        `json:"server"`
+	Routes []headerRoute `json:"routes"`
+}
+
+// headerRoute adds or removes headers for matching requests. Path is a
+// pattern like the runtime mocks' ("/users/{id}", "/files/*").
+type headerRoute struct {
+	Method  string            `json:"method,omitempty"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Remove  []string          `json:"remove,omitempty"`
+}
+
+// securityHeaders is the preset enabled by "security" or SECURITY_HEADERS=true.
+var securityHeaders = map[string]string{
+	"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+	"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+	"X-Content-Type-Options":    "nosniff",
+	"Referrer-Policy":           "no-referrer",
+	"X-Frame-Options":           "DENY",
+}
+
+// defaultHeaderConfig keeps the headers every handler used to set.
+func defaultHeaderConfig() *headerConfig {
+	return &headerConfig{Defaults: map[string]string{
+		"X-Served-By": "dummy-logger-server",
+		"X-Timestamp": "{{now}}",
+	}}
+}
+
+// loadHeaderConfig merges HEADERS_FILE into the defaults.
+func loadHeaderConfig(path string) (*headerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file headerConfig
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	c := defaultHeaderConfig()
+	for name, value := range file.Defaults {
+		c.Defaults[name] = value
+	}
+	c.Remove, c.Security, c.Server = file.Remove, file.Security, file.Server
+	for i, route := range file.Routes {
+		if route.Path == "" {
+			return nil, fmt.Errorf("%s: route %d has no path", path, i)
+		}
+		route.Method = strings.ToUpper(route.Method)
+		c.Routes = append(c.Routes, route)
+	}
+	return c, nil
+}
+
+func (c *headerConfig) matches(route headerRoute, r *http.Request) bool {
+	return (route.Method == "" || route.Method == "*" || route.Method == r.Method) && pathMatches(route.Path, r.URL.Path)
+}
+
+// middleware sets the configured headers before the handler runs and strips
+// the removed ones when the response is written.
+func (c *headerConfig) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		now := clock.Now().Format(time.RFC3339)
+		set := func(headers map[string]string) {
+			for name, value := range headers {
+				h.Set(name, strings.ReplaceAll(value, "{{now}}", now))
+			}
+		}
+		if c.Server != "" {
+			h.Set("Server", c.Server)
+		}
+		if c.Security {
+			set(securityHeaders)
+		}
+		set(c.Defaults)
+		remove := c.Remove
+		for _, route := range c.Routes {
+			if c.matches(route, r) {
+				set(route.Headers)
+				remove = append(remove[:len(remove):len(remove)], route.Remove...)
+			}
+		}
+		if len(remove) > 0 {
+			w = &headerStripper{ResponseWriter: w, remove: remove}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// headerStripper deletes headers just before they are sent.
+type headerStripper struct {
+	http.ResponseWriter
+	remove []string
+	wrote  bool
+}
+
+func (hs *headerStripper) WriteHeader(code int) {
+	if !hs.wrote {
+		hs.wrote = true
+		for _, name := range hs.remove {
+			hs.Header().Del(name)
+		}
+	}
+	hs.ResponseWriter.WriteHeader(code)
+}
+
+func (hs *headerStripper) Write(b []byte) (int, error) {
+	if !hs.wrote {
+		hs.WriteHeader(http.StatusOK)
+	}
+	return hs.ResponseWriter.Write(b)
+}
+
+func (hs *headerStripper) Unwrap() http.ResponseWriter {
+	return hs.ResponseWriter
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// Health states reported by the health endpoints and accepted by the admin override.
+const (
+	healthHealthy   = "healthy"
+	healthDegraded  = "degraded"
+	healthUnhealthy = "unhealthy"
+)
+
+// healthCheck is one readiness condition, e.g. "responses" or "spec".
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// healthState backs /health, /health/live and /health/ready. Tests can force a
+// state or slow responses through the admin API to exercise orchestrators and
+// load balancers.
+type healthState struct {
+	started    time.Time
+	namespaces *namespaceManager
+
+	mu     sync.Mutex
+	checks []healthCheck
+	forced healthOverride
+}
+
+// healthOverride is the admin-controlled part of the health state.
+type healthOverride struct {
+	// Live and Ready force the state of /health/live and /health/ready
+	// ("healthy", "degraded" or "unhealthy"); empty reports the real state.
+	Live  string `json:"live,omitempty"`
+	Ready string `json:"ready,omitempty"`
+	// DelayMs delays every health response to simulate a slow health check.
+	DelayMs int `json:"delay_ms,omitempty"`
+}
+
+// newHealthState starts without checks; the parts that can break while
+// serving register theirs with addCheck.
+func newHealthState(namespaces *namespaceManager) *healthState {
+	return &healthState{started: time.Now(), namespaces: namespaces}
+}
+
+func (h *healthState) addCheck(name string, check func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, healthCheck{name: name, check: check})
+}
+
+func (h *healthState) override() healthOverride {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.forced
+}
+
+// setOverride replaces the forced state and returns the one it replaced.
+func (h *healthState) setOverride(o healthOverride) (healthOverride, error) {
+	for _, state := range []string{o.Live, o.Ready} {
+		switch state {
+		case "", healthHealthy, healthDegraded, healthUnhealthy:
+		default:
+			return healthOverride{}, fmt.Errorf("unknown health state %q (want healthy, degraded or unhealthy)", state)
+		}
+	}
+	if o.DelayMs < 0 {
+		return healthOverride{}, fmt.Errorf("delay_ms must not be negative")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	previous := h.forced
+	h.forced = o
+	return previous, nil
+}
+
+// readiness runs every check and returns the overall state and per-check results.
+func (h *healthState) readiness() (string, map[string]string) {
+	h.mu.Lock()
+	checks := append([]healthCheck(nil), h.checks...)
+	h.mu.Unlock()
+
+	state := healthHealthy
+	results := make(map[string]string, len(checks))
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			results[c.name] = err.Error()
+			state = healthUnhealthy
+		} else {
+			results[c.name] = "ok"
+		}
+	}
+	return state, results
+}
+
+// counts summarizes what the server currently holds.
+func (h *healthState) counts() map[string]int {
+	counts := map[string]int{"namespaces": 0, "runtime_mocks": 0, "captured_requests": 0}
+	for _, ns := range h.namespaces.all() {
+		counts["namespaces"]++
+		counts["runtime_mocks"] += ns.mocks.len()
+		counts["captured_requests"] += ns.journal.len()
+		if ns.store != nil {
+			for _, name := range ns.store.collectionNames() {
+				records, _ := ns.store.list(name)
+				counts["records"] += len(records)
+			}
+		}
+	}
+	return counts
+}
+
+func (h *healthState) respond(w http.ResponseWriter, r *http.Request, state string, extra map[string]interface{}) {
+	if delay := h.override().DelayMs; delay > 0 && !wait(r, time.Duration(delay)*time.Millisecond) {
+		abandon(w, r)
+		return
+	}
+	uptime := time.Since(h.started)
+	body := map[string]interface{}{
+		"status":         state,
+		"timestamp":      clock.Now().Format(time.RFC3339),
+		"server":         "dummy-logger-go-server",
+		"version":        version,
+		"uptime":         uptime.Round(time.Second).String(),
+		"uptime_seconds": int64(uptime.Seconds()),
+	}
+	for k, v := range extra {
+		body[k] = v
+	}
+	status := http.StatusOK
+	if state == healthUnhealthy {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// handleLive reports that the process is running and serving requests.
+func (h *healthState) handleLive(w http.ResponseWriter, r *http.Request) {
+	state := healthHealthy
+	if forced := h.override().Live; forced != "" {
+		state = forced
+	}
+	h.respond(w, r, state, nil)
+}
+
+// handleReady reports whether the server can answer traffic correctly.
+func (h *healthState) handleReady(w http.ResponseWriter, r *http.Request) {
+	state, checks := h.readiness()
+	if forced := h.override().Ready; forced != "" {
+		state = forced
+	}
+	h.respond(w, r, state, map[string]interface{}{"checks": checks, "counts": h.counts()})
+}
+
+// handleHealth is the combined endpoint kept for existing probes: it is
+// unhealthy when either liveness or readiness is.
+func (h *healthState) handleHealth(w http.ResponseWriter, r *http.Request) {
+	state, checks := h.readiness()
+	o := h.override()
+	if o.Ready != "" {
+		state = o.Ready
+	}
+	for _, forced := range []string{o.Live, state} {
+		if forced == healthUnhealthy || (forced == healthDegraded && state == healthHealthy) {
+			state = forced
+		}
+	}
+	h.respond(w, r, state, map[string]interface{}{"checks": checks, "counts": h.counts()})
+}
+
+// middleware answers the health endpoints before the router, so probes are
+// not subject to client allow lists, rate limits or runtime mocks.
+func (h *healthState) middleware(next http.Handler) http.Handler {
+	routes := map[string]http.HandlerFunc{
+		"/health":       h.handleHealth,
+		"/health/live":  h.handleLive,
+		"/health/ready": h.handleReady,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handle := routes[r.URL.Path]; handle != nil && r.Method == http.MethodGet {
+			handle(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// specFileCheck reports whether the spec file still loads, re-reading it only
+// when it changed, so a broken edit shows before a restart depends on it.
+func specFileCheck(file string) func() error {
+	var mu sync.Mutex
+	var modTime time.Time
+	var lastErr error
+	return func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().Equal(modTime) {
+			modTime = info.ModTime()
+			_, lastErr = loadOpenAPISpec(file)
+		}
+		return lastErr
+	}
+}
+
+// checkNames lists the registered readiness checks in a stable order.
+func (h *healthState) checkNames() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	names := make([]string, 0, len(h.checks))
+	for _, c := range h.checks {
+		names = append(names, c.name)
+	}
+	sort.Strings(names)
+	return names
+}
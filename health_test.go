@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthDelayEndsWithTheRequest(t *testing.T) {
+	h := newHealthState(newNamespaceManager("X-Mock-Session", 0, 10, nil))
+	if _, err := h.setOverride(healthOverride{DelayMs: 10000}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		ctx    func() (context.Context, context.CancelFunc)
+		status int
+	}{
+		{"client gone", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, cancel
+		}, 200}, // nothing written
+		{"server timeout", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 10*time.Millisecond)
+		}, 503},
+	}
+	for _, tt := range tests {
+		ctx, cancel := tt.ctx()
+		w := httptest.NewRecorder()
+		start := time.Now()
+		h.handleLive(w, httptest.NewRequest("GET", "/health/live", nil).WithContext(ctx))
+		cancel()
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: handler held for %v", tt.name, elapsed)
+		}
+		if w.Code != tt.status || (tt.status == 200 && w.Body.Len() > 0) {
+			t.Errorf("%s: status %d with %d body bytes, want %d", tt.name, w.Code, w.Body.Len(), tt.status)
+		}
+	}
+}
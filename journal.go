@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// journalEntry is one captured request/response exchange.
+type journalEntry struct {
+	ID              int64               `json:"id"`
+	Namespace       string              `json:"namespace,omitempty"`
+	Time            string              `json:"time"`
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	Query           string              `json:"query,omitempty"`
+	RemoteAddr      string              `json:"remote_addr"`
+	ClientAddr      string              `json:"client_addr"`
+	SocketPeer      string              `json:"socket_peer,omitempty"` // the proxy, with PROXY protocol
+	Profile         string              `json:"profile,omitempty"`     // client profile from CLIENTS_FILE
+	Listener        string              `json:"listener,omitempty"`
+	Headers         http.Header         `json:"headers"`
+	Body            journalText         `json:"body,omitempty"`
+	Status          int                 `json:"status"`
+	Layer           string              `json:"layer,omitempty"` // response layer that served a file route
+	ResponseHeaders map[string][]string `json:"response_headers"`
+	ResponseBody    journalText         `json:"response_body,omitempty"`
+	// ResponseBodyBase64 marks a binary response body stored base64-encoded.
+	ResponseBodyBase64 bool `json:"response_body_base64,omitempty"`
+	// ResponseBodyTruncated marks a response body cut at LOG_BODY_MAX_BYTES.
+	ResponseBodyTruncated bool    `json:"response_body_truncated,omitempty"`
+	DurationMs            float64 `json:"duration_ms"`
+	// Outcome is set when the exchange did not complete normally: "client
+	// aborted", "write failed" or "server timeout". Status is 0 when no
+	// response was sent.
+	Outcome        string `json:"outcome,omitempty"`
+	Error          string `json:"error,omitempty"`
+	BytesDelivered int64  `json:"bytes_delivered"`
+	// RawRequest is the request exactly as received (RAW_CAPTURE=true):
+	// request line, headers in their original order and casing, and body.
+	RawRequest          journalText `json:"raw_request,omitempty"`
+	RawRequestBase64    bool        `json:"raw_request_base64,omitempty"`
+	RawRequestTruncated bool        `json:"raw_request_truncated,omitempty"`
+	// Timing breaks the exchange down by phase (LOG_CONNECTIONS=true).
+	Timing *requestTiming `json:"timing,omitempty"`
+}
+
+// journalText is captured text. It shares the bytes captured for the request
+// instead of copying them into a string, and is encoded as a JSON string.
+type journalText []byte
+
+func (t journalText) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(t))
+}
+
+func (t *journalText) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*t = journalText(s)
+	return nil
+}
+
+// journal keeps the most recent exchanges in a fixed-size ring.
+type journal struct {
+	mu      sync.Mutex
+	entries []journalEntry
+	next    int
+	full    bool
+	seq     int64 // id of the last captured exchange
+}
+
+func newJournal(size int) *journal {
+	if size < 1 {
+		size = 1
+	}
+	return &journal{entries: make([]journalEntry, size)}
+}
+
+func (j *journal) add(e journalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq++
+	e.ID = j.seq
+	j.entries[j.next] = e
+	j.next = (j.next + 1) % len(j.entries)
+	if j.next == 0 {
+		j.full = true
+	}
+}
+
+// list returns the captured exchanges, oldest first.
+func (j *journal) list() []journalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.full {
+		return append([]journalEntry(nil), j.entries[:j.next]...)
+	}
+	out := make([]journalEntry, 0, len(j.entries))
+	out = append(out, j.entries[j.next:]...)
+	return append(out, j.entries[:j.next]...)
+}
+
+func (j *journal) len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.full {
+		return len(j.entries)
+	}
+	return j.next
+}
+
+// stats returns the number of captured exchanges, the ring capacity and the
+// size of the captured bodies in bytes.
+func (j *journal) stats() (entries, capacity, bytes int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries = j.next
+	if j.full {
+		entries = len(j.entries)
+	}
+	for _, e := range j.entries[:entries] {
+		bytes += len(e.Body) + len(e.ResponseBody)
+	}
+	return entries, len(j.entries), bytes
+}
+
+// sequence is the id of the last captured exchange.
+func (j *journal) sequence() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq
+}
+
+// restoreSequence continues the numbering from a snapshot, past the ids of
+// exchanges still held.
+func (j *journal) restoreSequence(n int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, e := range j.entries {
+		if e.ID > n {
+			n = e.ID
+		}
+	}
+	j.seq = n
+}
+
+// clear drops every captured exchange and returns how many there were.
+func (j *journal) clear() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.clearLocked()
+}
+
+// reset clears the journal and numbers the next captured exchange 1 again.
+// Both happen under one lock, so no id is ever held by two entries.
+func (j *journal) reset() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq = 0
+	return j.clearLocked()
+}
+
+func (j *journal) clearLocked() int {
+	dropped := j.next
+	if j.full {
+		dropped = len(j.entries)
+	}
+	j.entries = make([]journalEntry, len(j.entries))
+	j.next = 0
+	j.full = false
+	return dropped
+}
+
+// journalFilter selects captured exchanges. Zero values match everything.
+type journalFilter struct {
+	Method   string `json:"method,omitempty"`
+	Path     string `json:"path,omitempty"` // same pattern syntax as runtime mocks
+	Status   int    `json:"status,omitempty"`
+	Contains string `json:"contains,omitempty"` // substring of the request body
+	SinceID  int64  `json:"since_id,omitempty"`
+}
+
+// journalFilterFromQuery reads ?method=&path=&status=&contains=&since_id=.
+func journalFilterFromQuery(q map[string][]string) (journalFilter, error) {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	f := journalFilter{Method: get("method"), Path: get("path"), Contains: get("contains")}
+	var err error
+	if v := get("status"); v != "" {
+		if f.Status, err = strconv.Atoi(v); err != nil {
+			return f, err
+		}
+	}
+	if v := get("since_id"); v != "" {
+		if f.SinceID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+func (f journalFilter) matches(e journalEntry) bool {
+	switch {
+	case e.ID <= f.SinceID:
+		return false
+	case f.Method != "" && !strings.EqualFold(f.Method, e.Method):
+		return false
+	case f.Path != "" && !pathMatches(f.Path, e.Path):
+		return false
+	case f.Status != 0 && f.Status != e.Status:
+		return false
+	case f.Contains != "" && !bytes.Contains(e.Body, []byte(f.Contains)):
+		return false
+	}
+	return true
+}
+
+// search returns the captured exchanges matching f, oldest first.
+func (j *journal) search(f journalFilter) []journalEntry {
+	out := make([]journalEntry, 0)
+	for _, e := range j.list() {
+		if f.matches(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
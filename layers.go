@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// responseLayer is one directory of response files. Layers are stacked in
+// order (base -> env -> local): a file in a higher layer replaces the file at
+// the same relative path in the layers below, sidecars included.
+type responseLayer struct {
+	Name string
+	Dir  string
+}
+
+// parseResponseLayers reads RESPONSE_LAYERS, e.g.
+// "base=responses,staging=overlays/staging,local=local"; a bare directory is
+// named after its last element.
+func parseResponseLayers(spec string) ([]responseLayer, error) {
+	var layers []responseLayer
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, dir, ok := strings.Cut(item, "=")
+		if !ok {
+			name, dir = filepath.Base(item), item
+		}
+		if name == "" || dir == "" {
+			return nil, fmt.Errorf("layer %q must look like name=dir", item)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate layer name %q", name)
+		}
+		seen[name] = true
+		layers = append(layers, responseLayer{Name: name, Dir: dir})
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no layers given")
+	}
+	return layers, nil
+}
+
+// layerFile is the winning copy of a relative path in a stack of layers.
+type layerFile struct {
+	Layer string
+	Path  string
+}
+
+// mergeLayers returns every relative path (slash-separated) found in the
+// layers, resolved to the highest layer that has it.
+func mergeLayers(layers []responseLayer) (map[string]layerFile, error) {
+	merged := make(map[string]layerFile)
+	for _, layer := range layers {
+		err := filepath.WalkDir(layer.Dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(layer.Dir, path)
+			if err != nil {
+				return err
+			}
+			merged[filepath.ToSlash(rel)] = layerFile{Layer: layer.Name, Path: path}
+			return nil
+		})
+		if err != nil {
+			return merged, fmt.Errorf("layer %s: %w", layer.Name, err)
+		}
+	}
+	return merged, nil
+}
+
+// selectLayers stacks the named layers on top of the base (first) layer,
+// keeping the configured order, so endpoints an overlay does not override
+// still come from base. Unknown names are returned separately.
+func selectLayers(all []responseLayer, names []string) (selected []responseLayer, unknown []string) {
+	want := make(map[string]bool)
+	for _, name := range names {
+		want[name] = true
+	}
+	for i, layer := range all {
+		if i == 0 || want[layer.Name] {
+			selected = append(selected, layer)
+			delete(want, layer.Name)
+		}
+	}
+	for name := range want {
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+	return selected, unknown
+}
+
+func layerNames(layers []responseLayer) []string {
+	names := make([]string, 0, len(layers))
+	for _, layer := range layers {
+		names = append(names, layer.Name)
+	}
+	return names
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelectLayers(t *testing.T) {
+	all := []responseLayer{{Name: "base"}, {Name: "staging"}, {Name: "acme"}, {Name: "local"}}
+	tests := []struct {
+		names       []string
+		want        []string
+		wantUnknown []string
+	}{
+		{[]string{"staging"}, []string{"base", "staging"}, nil},
+		{[]string{"acme"}, []string{"base", "acme"}, nil},
+		{[]string{"local", "staging"}, []string{"base", "staging", "local"}, nil},
+		{[]string{"base"}, []string{"base"}, nil},
+		{[]string{"nope", "acme"}, []string{"base", "acme"}, []string{"nope"}},
+		{nil, []string{"base"}, nil},
+	}
+	for _, tt := range tests {
+		selected, unknown := selectLayers(all, tt.names)
+		if got := layerNames(selected); !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(unknown, tt.wantUnknown) {
+			t.Errorf("%v: got %v (unknown %v), want %v (unknown %v)", tt.names, got, unknown, tt.want, tt.wantUnknown)
+		}
+	}
+}
+
+func TestParseResponseLayers(t *testing.T) {
+	layers, err := parseResponseLayers("base=responses, staging=overlays/staging,local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []responseLayer{{"base", "responses"}, {"staging", "overlays/staging"}, {"local", "local"}}
+	if !reflect.DeepEqual(layers, want) {
+		t.Errorf("got %v, want %v", layers, want)
+	}
+	for _, spec := range []string{"", "a=x,a=y", "=dir", "name="} {
+		if _, err := parseResponseLayers(spec); err == nil {
+			t.Errorf("%q: accepted, want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// mainListener is one listener of the main server with the name it has in
+// the logs: "tcp :8080", "unix /run/mock.sock" or "systemd web (tcp [::]:80)".
+type mainListener struct {
+	net.Listener
+	name string
+}
+
+// listenersFromEnv opens the main server's listeners: sockets passed by
+// systemd socket activation (LISTEN_FDS), a unix socket (SOCKET, with
+// SOCKET_MODE permissions such as 0660) and the TCP port (PORT). The TCP port
+// is opened by default, but only when PORT is set if there is another listener.
+func listenersFromEnv() ([]mainListener, error) {
+	listeners, err := systemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	if path := os.Getenv("SOCKET"); path != "" {
+		ln, err := listenUnix(path, os.Getenv("SOCKET_MODE"))
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+	port := os.Getenv("PORT")
+	if port == "" && len(listeners) == 0 {
+		port = "8080"
+	}
+	if port != "" {
+		ln, err := net.Listen("tcp", ":"+port)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, mainListener{Listener: ln, name: "tcp :" + port})
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on a unix socket, replacing a stale socket file.
+func listenUnix(path, mode string) (mainListener, error) {
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return mainListener{}, err
+	}
+	name := "unix " + path
+	if mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || perm > 0o777 {
+			ln.Close()
+			return mainListener{}, fmt.Errorf("invalid SOCKET_MODE %q, want octal permissions such as 0660", mode)
+		}
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			ln.Close()
+			return mainListener{}, err
+		}
+		name += fmt.Sprintf(" (mode %04o)", perm)
+	}
+	return mainListener{Listener: ln, name: name}, nil
+}
+
+// systemdListeners returns the sockets systemd passed to this process
+// (LISTEN_PID, LISTEN_FDS from descriptor 3 on, names from LISTEN_FDNAMES).
+// The variables are cleared so child processes do not claim them too.
+func systemdListeners() ([]mainListener, error) {
+	if pid := os.Getenv("LISTEN_PID"); pid == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	var listeners []mainListener
+	for i := 0; i < count; i++ {
+		const firstFD = 3
+		name := fmt.Sprintf("fd%d", firstFD+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(firstFD+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("systemd socket %s: %w", name, err)
+		}
+		addr := ln.Addr()
+		listeners = append(listeners, mainListener{Listener: ln, name: fmt.Sprintf("systemd %s (%s %s)", name, addr.Network(), addr)})
+	}
+	return listeners, nil
+}
+
+func closeListeners(listeners []mainListener) {
+	for _, ln := range listeners {
+		ln.Close()
+	}
+}
+
+type listenerKey struct{}
+
+// listenerName is the name of the listener r arrived on.
+func listenerName(r *http.Request) string {
+	name, _ := r.Context().Value(listenerKey{}).(string)
+	return name
+}
+
+// withListener records the listener's name in the contexts of its connections.
+func withListener(name string, connContext func(context.Context, net.Conn) context.Context) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		return connContext(context.WithValue(ctx, listenerKey{}, name), c)
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// requestBody is the request body, read once by the logging middleware and
+// shared through the request context so handlers and the log workers do not
+// read or parse it again.
+type requestBody struct {
+	raw []byte
+
+	once   sync.Once
+	parsed interface{}
+	err    error
+}
+
+type requestBodyKey struct{}
+
+// json parses the body on first use. The result is shared: callers must not modify it.
+func (b *requestBody) json() (interface{}, error) {
+	b.once.Do(func() {
+		b.err = json.Unmarshal(b.raw, &b.parsed)
+	})
+	return b.parsed, b.err
+}
+
+// bodyFrom returns the captured request body, reading it now for requests that
+// did not pass through the logging middleware.
+func bodyFrom(r *http.Request) *requestBody {
+	if b, ok := r.Context().Value(requestBodyKey{}).(*requestBody); ok {
+		return b
+	}
+	raw, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(raw))
+	return &requestBody{raw: raw}
+}
+
+// logEvent is the compact record of one exchange. The request path only fills
+// it in; formatting and writing happen on the log workers.
+type logEvent struct {
+	start            time.Time
+	method           string
+	url              string
+	path             string
+	rawQuery         string
+	proto            string
+	host             string
+	listener         string
+	remoteAddr       string
+	socketPeer       string // set when the connection came through the PROXY protocol
+	clientAddr       string
+	profile          string // client profile and why it matched
+	requestURI       string
+	contentLength    int64
+	transferEncoding []string
+	close            bool
+	header           http.Header
+	body             *requestBody
+	raw              *rawRequest    // nil unless RAW_CAPTURE is on
+	timing           *requestTiming // nil unless LOG_CONNECTIONS is on
+	status           int            // 0 when no response was sent
+	layer            string         // response layer of a file route, with RESPONSE_LAYERS
+	responseType     string
+	responseBody     []byte // the first bodyMax bytes
+	responseSize     int64
+	delivered        int64
+	outcome          string
+	err              error
+	duration         time.Duration
+}
+
+// logPipeline writes request logs from a bounded queue on background workers.
+// With zero workers every event is written synchronously on the request goroutine.
+type logPipeline struct {
+	queue   chan *logEvent
+	workers int
+	block   bool // wait for room instead of dropping when the queue is full
+	bodyMax int  // response bytes captured per request
+
+	writers sync.Pool // *logWriter for synchronous writes
+
+	dropped    int64 // total events dropped because the queue was full
+	unreported int64 // drops not yet mentioned in the log
+}
+
+func newLogPipeline(queueSize, workers int, block bool, bodyMax int) *logPipeline {
+	p := &logPipeline{workers: workers, block: block, bodyMax: bodyMax}
+	p.writers.New = func() interface{} { return newLogWriter() }
+	if workers > 0 {
+		p.queue = make(chan *logEvent, queueSize)
+		for i := 0; i < workers; i++ {
+			go p.run()
+		}
+	}
+	return p
+}
+
+// stats reports the queue length, its capacity and the number of dropped events.
+func (p *logPipeline) stats() (queued, capacity int, dropped int64) {
+	return len(p.queue), cap(p.queue), atomic.LoadInt64(&p.dropped)
+}
+
+// check reports a full queue: request logs are being dropped or requests are
+// waiting for the log workers.
+func (p *logPipeline) check() error {
+	if queued, capacity, _ := p.stats(); capacity > 0 && queued >= capacity {
+		return fmt.Errorf("log queue is full (%d events)", capacity)
+	}
+	return nil
+}
+
+func (p *logPipeline) enqueue(e *logEvent) {
+	if p.queue == nil {
+		lw := p.writers.Get().(*logWriter)
+		lw.write(e)
+		p.writers.Put(lw)
+		return
+	}
+	if p.block {
+		p.queue <- e
+		return
+	}
+	select {
+	case p.queue <- e:
+	default:
+		atomic.AddInt64(&p.dropped, 1)
+		atomic.AddInt64(&p.unreported, 1)
+	}
+}
+
+func (p *logPipeline) run() {
+	lw := newLogWriter()
+	for e := range p.queue {
+		if n := atomic.SwapInt64(&p.unreported, 0); n > 0 {
+			log.Printf("WARNING: dropped %d request log(s) because the log queue was full", n)
+		}
+		lw.write(e)
+	}
+}
+
+// logWriter formats events into a reused buffer with a logger created once.
+type logWriter struct {
+	buf bytes.Buffer
+	l   *log.Logger
+}
+
+func newLogWriter() *logWriter {
+	lw := &logWriter{}
+	lw.l = log.New(&lw.buf, log.Prefix(), log.Flags())
+	return lw
+}
+
+// write formats every line of the event into the buffer and writes it with a
+// single call, so the lines of concurrent requests never interleave.
+func (lw *logWriter) write(e *logEvent) {
+	lw.buf.Reset()
+	l := lw.l
+
+	l.Println("=== INCOMING REQUEST ===")
+	l.Printf("Timestamp: %s", e.start.Format(time.RFC3339))
+	l.Printf("Method: %s", e.method)
+	l.Printf("URL: %s", e.url)
+	l.Printf("Path: %s", e.path)
+	l.Printf("Raw Query: %s", e.rawQuery)
+	l.Printf("Protocol: %s", e.proto)
+	l.Printf("Host: %s", e.host)
+	l.Printf("Listener: %s", e.listener)
+	l.Printf("Remote Address: %s", e.remoteAddr)
+	if e.socketPeer != "" {
+		l.Printf("Socket Peer: %s (PROXY protocol)", e.socketPeer)
+	}
+	l.Printf("Client Address: %s", e.clientAddr)
+	if e.profile != "" {
+		l.Printf("Client Profile: %s", e.profile)
+	}
+	l.Printf("Request URI: %s", e.requestURI)
+	l.Printf("Content Length: %d", e.contentLength)
+	l.Printf("Transfer Encoding: %v", e.transferEncoding)
+	l.Printf("Close: %t", e.close)
+
+	// Names are sorted so identical requests produce identical logs
+	l.Println("--- HEADERS ---")
+	for _, name := range sortedKeys(e.header) {
+		for _, value := range e.header[name] {
+			l.Printf("Header: %s = %s", name, value)
+		}
+	}
+
+	// The request as it arrived on the wire, quoted so casing, spacing and
+	// stray control characters are visible
+	if e.raw != nil {
+		l.Println("--- RAW REQUEST ---")
+		if e.raw.truncated {
+			l.Printf("Raw Size: %d bytes (capture truncated to %d)", e.raw.size, len(e.raw.data))
+		} else {
+			l.Printf("Raw Size: %d bytes", e.raw.size)
+		}
+		for _, line := range e.raw.head() {
+			l.Printf("Raw Line: %q", line)
+		}
+	}
+
+	if query, err := url.ParseQuery(e.rawQuery); err == nil && len(query) > 0 {
+		l.Println("--- QUERY PARAMETERS ---")
+		for _, key := range sortedKeys(query) {
+			for _, value := range query[key] {
+				l.Printf("Query Param: %s = %s", key, value)
+			}
+		}
+	}
+
+	if raw := e.body.raw; len(raw) > 0 {
+		l.Println("--- REQUEST BODY ---")
+		l.Printf("Body Length: %d bytes", len(raw))
+		if contentType := e.header.Get("Content-Type"); !isTextual(contentType, raw) {
+			l.Printf("Body Content: <binary %s>", contentType)
+		} else if payload, err := e.body.json(); err != nil {
+			l.Printf("Body Content: %s", raw)
+			l.Printf("JSON Parse Status: the request cannot be parsed as json - %v", err)
+		} else {
+			l.Printf("Body Content: %s", raw)
+			l.Printf("JSON Parse Status: the request can be successfully parsed as json")
+			if prettyJSON, err := json.MarshalIndent(payload, "", "  "); err == nil {
+				l.Printf("Pretty Printed JSON:\n%s", prettyJSON)
+			}
+		}
+
+		if e.header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+			if form, err := url.ParseQuery(string(raw)); err == nil {
+				l.Println("--- FORM DATA ---")
+				for _, key := range sortedKeys(form) {
+					for _, value := range form[key] {
+						l.Printf("Form Field: %s = %s", key, value)
+					}
+				}
+			}
+		}
+	}
+
+	l.Println("--- RESPONSE ---")
+	if e.status == 0 {
+		l.Printf("Status Code: none (no response was sent)")
+	} else {
+		l.Printf("Status Code: %d", e.status)
+	}
+	if e.layer != "" {
+		l.Printf("Response Layer: %s", e.layer)
+	}
+	if e.err != nil {
+		l.Printf("Outcome: %s (%v)", e.outcome, e.err)
+	} else {
+		l.Printf("Outcome: %s", e.outcome)
+	}
+	l.Printf("Bytes Delivered: %d", e.delivered)
+	l.Printf("Response Body Length: %d bytes", e.responseSize)
+	if int64(len(e.responseBody)) < e.responseSize {
+		l.Printf("Response Body Captured: first %d bytes", len(e.responseBody))
+	}
+	if isTextual(e.responseType, e.responseBody) {
+		l.Printf("Response Body: %s", e.responseBody)
+	} else {
+		l.Printf("Response Body: <binary %s>", e.responseType)
+	}
+	l.Printf("Duration: %v", e.duration)
+	if t := e.timing; t != nil {
+		l.Println("--- TIMING ---")
+		l.Printf("Connection: #%d, request %d, %s", t.Connection, t.Request, t.describe())
+		l.Printf("Headers Read: %vms", t.HeadersMs)
+		l.Printf("Body Read: %vms", t.BodyMs)
+		l.Printf("Handler: %vms", t.HandlerMs)
+	}
+	l.Println("=== END REQUEST ===")
+	l.Println()
+
+	log.Writer().Write(lw.buf.Bytes())
+}
+
+// middleware captures every request and response. The body is read once and
+// shared through the context; the exchange is recorded in the namespace's
+// journal and handed to the log workers.
+func (p *logPipeline) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := clock.Now()
+
+		bodyStart := time.Now()
+		raw, err := io.ReadAll(r.Body)
+		bodyRead := time.Since(bodyStart)
+		if err != nil {
+			log.Printf("Error reading request body: %v", err)
+		}
+		body := &requestBody{raw: raw}
+		r.Body = io.NopCloser(bytes.NewReader(raw))
+		var layer string
+		ctx := context.WithValue(r.Context(), requestBodyKey{}, body)
+		r = r.WithContext(context.WithValue(ctx, responseLayerKey{}, &layer))
+		rawReq := rawRequestFrom(r)
+
+		// Create a response writer wrapper to capture response details
+		responseWriter := &responseWriterWrapper{
+			ResponseWriter: w,
+			statusCode:     200,
+			captureMax:     p.bodyMax,
+		}
+
+		// Call the next handler
+		handlerStart := time.Now()
+		next.ServeHTTP(responseWriter, r)
+		handlerTime := time.Since(handlerStart)
+		duration := clock.Since(start)
+
+		var timing *requestTiming
+		if info := connInfoFrom(r); info != nil {
+			timing = newRequestTiming(info, bodyRead, handlerTime)
+		}
+
+		// A client that went away or a broken connection is not a normal response
+		outcome, outcomeErr := requestOutcome(r.Context(), responseWriter.writeErr)
+		status := responseWriter.statusCode
+		if outcome != outcomeCompleted && !responseWriter.wroteHeader {
+			status = 0
+		}
+
+		header := r.Header.Clone()
+		client := clientAddress(r)
+		peer := ""
+		if sp := socketPeer(r); sp != r.RemoteAddr {
+			peer = loggedAddr(sp)
+		}
+		profileName, profileDesc := "", ""
+		if m := clientProfileFrom(r); m != nil {
+			profileName = m.profile.Name
+			profileDesc = fmt.Sprintf("%s (matched by %s)", m.profile.Name, m.reason)
+		}
+		p.enqueue(&logEvent{
+			start:            start,
+			method:           r.Method,
+			url:              r.URL.String(),
+			path:             r.URL.Path,
+			rawQuery:         r.URL.RawQuery,
+			proto:            r.Proto,
+			host:             r.Host,
+			listener:         listenerName(r),
+			remoteAddr:       loggedRemoteAddr(r),
+			socketPeer:       peer,
+			clientAddr:       client,
+			profile:          profileDesc,
+			requestURI:       r.RequestURI,
+			contentLength:    r.ContentLength,
+			transferEncoding: r.TransferEncoding,
+			close:            r.Close,
+			header:           header,
+			body:             body,
+			raw:              rawReq,
+			timing:           timing,
+			status:           status,
+			layer:            layer,
+			responseType:     responseWriter.Header().Get("Content-Type"),
+			responseBody:     responseWriter.responseBody,
+			responseSize:     responseWriter.bodySize,
+			delivered:        responseWriter.written,
+			outcome:          outcome,
+			err:              outcomeErr,
+			duration:         duration,
+		})
+
+		// Capture the exchange in the namespace's journal
+		if ns := namespaceFrom(r); ns != nil {
+			entry := journalEntry{
+				Namespace:       ns.name,
+				Time:            start.Format(time.RFC3339Nano),
+				Method:          r.Method,
+				Path:            r.URL.Path,
+				Query:           r.URL.RawQuery,
+				RemoteAddr:      loggedRemoteAddr(r),
+				ClientAddr:      client,
+				SocketPeer:      peer,
+				Profile:         profileName,
+				Listener:        listenerName(r),
+				Headers:         header,
+				Body:            raw,
+				Status:          status,
+				Layer:           layer,
+				ResponseHeaders: responseWriter.Header().Clone(),
+				ResponseBody:    responseWriter.responseBody,
+				DurationMs:      float64(duration.Microseconds()) / 1000,
+				BytesDelivered:  responseWriter.written,
+				Timing:          timing,
+			}
+			if outcome != outcomeCompleted {
+				entry.Outcome = outcome
+				entry.Error = outcomeErr.Error()
+			}
+			if rawReq != nil {
+				entry.RawRequest = rawReq.data
+				entry.RawRequestTruncated = rawReq.truncated
+				if !isTextual(r.Header.Get("Content-Type"), rawReq.data) {
+					entry.RawRequest = journalText(base64.StdEncoding.EncodeToString(rawReq.data))
+					entry.RawRequestBase64 = true
+				}
+			}
+			entry.ResponseBodyTruncated = responseWriter.bodySize > int64(len(responseWriter.responseBody))
+			if !isTextual(responseWriter.Header().Get("Content-Type"), responseWriter.responseBody) {
+				entry.ResponseBody = journalText(base64.StdEncoding.EncodeToString(responseWriter.responseBody))
+				entry.ResponseBodyBase64 = true
+			}
+			ns.journal.add(entry)
+		}
+	})
+}
+
+// loggedRemoteAddr is the client address; deterministic mode drops the
+// ephemeral port, which differs on every run.
+func loggedRemoteAddr(r *http.Request) string {
+	return loggedAddr(r.RemoteAddr)
+}
+
+func loggedAddr(addr string) string {
+	if deterministic {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			return host
+		}
+	}
+	return addr
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isTextual reports whether a body should be logged as text: textual content
+// types, or valid UTF-8 without control characters when the type is unknown.
+func isTextual(contentType string, data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "json"), strings.HasSuffix(mediaType, "xml"), strings.HasSuffix(mediaType, "yaml"),
+		mediaType == "application/javascript", mediaType == "application/x-www-form-urlencoded":
+		return true
+	case mediaType != "" && mediaType != "application/octet-stream":
+		return false
+	}
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, b := range data {
+		if b < 0x20 && b != '\n' && b != '\r' && b != '\t' {
+			return false
+		}
+	}
+	return true
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"io"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -90,180 +92,43 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// Logger middleware that logs everything about the request
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-
-		// Read the request body
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			log.Printf("Error reading request body: %v", err)
-		}
-		// Restore the body for the next handler
-		r.Body = io.NopCloser(strings.NewReader(string(body)))
-
-		// Log extensive request information
-		log.Println("=== INCOMING REQUEST ===")
-		log.Printf("Timestamp: %s", start.Format(time.RFC3339))
-		log.Printf("Method: %s", r.Method)
-		log.Printf("URL: %s", r.URL.String())
-		log.Printf("Path: %s", r.URL.Path)
-		log.Printf("Raw Query: %s", r.URL.RawQuery)
-		log.Printf("Protocol: %s", r.Proto)
-		log.Printf("Host: %s", r.Host)
-		log.Printf("Remote Address: %s", r.RemoteAddr)
-		log.Printf("Request URI: %s", r.RequestURI)
-		log.Printf("Content Length: %d", r.ContentLength)
-		log.Printf("Transfer Encoding: %v", r.TransferEncoding)
-		log.Printf("Close: %t", r.Close)
-
-		// Log all headers
-		log.Println("--- HEADERS ---")
-		for name, values := range r.Header {
-			for _, value := range values {
-				log.Printf("Header: %s = %s", name, value)
-			}
-		}
-
-		// Log query parameters
-		if len(r.URL.Query()) > 0 {
-			log.Println("--- QUERY PARAMETERS ---")
-			for key, values := range r.URL.Query() {
-				for _, value := range values {
-					log.Printf("Query Param: %s = %s", key, value)
-				}
-			}
-		}
-
-		// Log request body if present
-		if len(body) > 0 {
-			log.Println("--- REQUEST BODY ---")
-			log.Printf("Body Length: %d bytes", len(body))
-			log.Printf("Body Content: %s", string(body))
-
-			// Check if the request body can be parsed as JSON
-			var jsonPayload interface{}
-			if err := json.Unmarshal(body, &jsonPayload); err != nil {
-				log.Printf("JSON Parse Status: the request cannot be parsed as json - %v", err)
-			} else {
-				log.Printf("JSON Parse Status: the request can be successfully parsed as json")
-				// Pretty print the parsed JSON
-				prettyJSON, err := json.MarshalIndent(jsonPayload, "", "  ")
-				if err == nil {
-					log.Printf("Pretty Printed JSON:\n%s", string(prettyJSON))
-				}
-			}
-		}
-
-		// Log form data if present
-		if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
-			if err := r.ParseForm(); err == nil {
-				log.Println("--- FORM DATA ---")
-				for key, values := range r.Form {
-					for _, value := range values {
-						log.Printf("Form Field: %s = %s", key, value)
-					}
-				}
-			}
-		}
-
-		// Create a response writer wrapper to capture response details
-		responseWriter := &responseWriterWrapper{
-			ResponseWriter: w,
-			statusCode:     200,
-			responseBody:   make([]byte, 0),
-		}
-
-		// Call the next handler
-		next.ServeHTTP(responseWriter, r)
-
-		// Log response information
-		duration := time.Since(start)
-		log.Println("--- RESPONSE ---")
-		log.Printf("Status Code: %d", responseWriter.statusCode)
-		log.Printf("Response Body Length: %d bytes", len(responseWriter.responseBody))
-		log.Printf("Response Body: %s", string(responseWriter.responseBody))
-		log.Printf("Duration: %v", duration)
-		log.Println("=== END REQUEST ===")
-		log.Println()
-	})
-}
-
-// Response writer wrapper to capture response data
+// Response writer wrapper to capture response data, the bytes the connection
+// accepted and the first write error. Only the first captureMax bytes of the
+// body are kept.
 type responseWriterWrapper struct {
 	http.ResponseWriter
 	statusCode   int
+	captureMax   int
 	responseBody []byte
+	bodySize     int64 // bytes the handler wrote, captured or not
+	wroteHeader  bool
+	written      int64
+	writeErr     error
 }
 
 func (rw *responseWriterWrapper) WriteHeader(code int) {
 	rw.statusCode = code
+	rw.wroteHeader = true
 	rw.ResponseWriter.WriteHeader(code)
 }
 
 func (rw *responseWriterWrapper) Write(b []byte) (int, error) {
-	rw.responseBody = append(rw.responseBody, b...)
-	return rw.ResponseWriter.Write(b)
-}
-
-// Generic handler that serves static JSON responses
-// This handler accepts ANY JSON payload without validation
-func serveStaticJSON(filename string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Set response headers
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("X-Served-By", "dummy-logger-server")
-		w.Header().Set("X-Timestamp", time.Now().Format(time.RFC3339))
-
-		// For POST/PUT requests, try to parse and log the JSON payload (but don't validate structure)
-		if r.Method == "POST" || r.Method == "PUT" {
-			// Re-read the body since it was already read in the logging middleware
-			body, err := io.ReadAll(r.Body)
-			if err == nil && len(body) > 0 {
-				// Try to parse as JSON to validate it's valid JSON (but accept any structure)
-				var jsonPayload interface{}
-				if err := json.Unmarshal(body, &jsonPayload); err != nil {
-					log.Printf("⚠️  WARNING: Invalid JSON payload received: %v", err)
-					log.Printf("Raw payload: %s", string(body))
-				} else {
-					log.Printf("✅ Valid JSON payload received and logged above")
-					// Pretty print the parsed JSON
-					prettyJSON, _ := json.MarshalIndent(jsonPayload, "", "  ")
-					log.Printf("Parsed JSON structure:\n%s", string(prettyJSON))
-				}
-			}
-		}
-
-		// Read the static JSON file
-		filePath := filepath.Join("responses", filename)
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			log.Printf("Error reading file %s: %v", filePath, err)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Failed to read response file",
-				"file":  filename,
-			})
-			return
-		}
-
-		// For POST requests, return 201 Created
-		if r.Method == "POST" {
-			w.WriteHeader(http.StatusCreated)
-		}
-
-		// Write the static JSON response (completely independent of input payload)
-		w.Write(data)
+	rw.wroteHeader = true
+	if room := rw.captureMax - len(rw.responseBody); room > 0 {
+		rw.responseBody = append(rw.responseBody, b[:min(room, len(b))]...)
+	}
+	rw.bodySize += int64(len(b))
+	n, err := rw.ResponseWriter.Write(b)
+	rw.written += int64(n)
+	if err != nil && rw.writeErr == nil {
+		rw.writeErr = err
 	}
+	return n, err
 }
 
-// Handler for DELETE requests
-func handleDelete(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Served-By", "dummy-logger-server")
-	w.Header().Set("X-Timestamp", time.Now().Format(time.RFC3339))
-	w.WriteHeader(http.StatusNoContent)
+// Unwrap lets http.ResponseController reach the connection (e.g. to flush).
+func (rw *responseWriterWrapper) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
 }
 
 // Catch-all handler for unmatched routes, but dont return error
@@ -271,8 +136,6 @@ func catchAllHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("!!! UNMATCHED ROUTE !!! Method: %s, Path: %s", r.Method, r.URL.Path)
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Served-By", "dummy-logger-server")
-	w.Header().Set("X-Timestamp", time.Now().Format(time.RFC3339))
 	w.WriteHeader(http.StatusOK)
 
 	response := map[string]interface{}{
@@ -289,55 +152,260 @@ func catchAllHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	// Subcommands other than "serve" control an already running server.
+	if len(os.Args) > 1 && os.Args[1] != "serve" {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	serve()
+}
+
+// serve configures the routes from the environment and runs the server.
+func serve() {
 	// Create router
 	r := mux.NewRouter()
 
+	// Mutable state that admin snapshots capture and restore
+	state := newStateRegistry()
+
+	// DETERMINISTIC=true makes identical test runs produce identical output: the
+	// clock starts frozen at DETERMINISTIC_TIME and only moves through the admin
+	// API, generated values are seeded from DETERMINISTIC_SEED, and request logs
+	// are written synchronously with mock-clock timestamps.
+	if os.Getenv("DETERMINISTIC") == "true" {
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		if v := os.Getenv("DETERMINISTIC_TIME"); v != "" {
+			var err error
+			if start, err = time.Parse(time.RFC3339, v); err != nil {
+				log.Fatalf("Invalid DETERMINISTIC_TIME: %v", err)
+			}
+		}
+		seed := int64(1)
+		if v := os.Getenv("DETERMINISTIC_SEED"); v != "" {
+			var err error
+			if seed, err = strconv.ParseInt(v, 10, 64); err != nil {
+				log.Fatalf("Invalid DETERMINISTIC_SEED: %v", err)
+			}
+		}
+		enableDeterministic(start, seed)
+		log.SetFlags(0)
+		log.SetOutput(clockLogWriter{out: log.Writer()})
+	}
+
 	// Add CORS middleware first to handle preflight requests early
 	r.Use(corsMiddleware)
 
-	// Add logging middleware
-	r.Use(loggingMiddleware)
+	// Add logging middleware. LOG_WORKERS background workers format and write
+	// request logs from a queue of LOG_QUEUE_SIZE events (LOG_WORKERS=0 writes
+	// synchronously); LOG_QUEUE_FULL=drop drops logs instead of waiting when it is full.
+	// Response bodies are captured for the log and the journal up to
+	// LOG_BODY_MAX_BYTES.
+	logWorkers := 1
+	if deterministic {
+		logWorkers = 0
+	}
+	if v := os.Getenv("LOG_WORKERS"); v != "" {
+		var err error
+		if logWorkers, err = strconv.Atoi(v); err != nil || logWorkers < 0 {
+			log.Fatalf("Invalid LOG_WORKERS: %q", v)
+		}
+	}
+	logQueueSize := 10000
+	if v := os.Getenv("LOG_QUEUE_SIZE"); v != "" {
+		var err error
+		if logQueueSize, err = strconv.Atoi(v); err != nil || logQueueSize < 1 {
+			log.Fatalf("Invalid LOG_QUEUE_SIZE: %q", v)
+		}
+	}
+	logBodyMax := 64 << 10
+	if v := os.Getenv("LOG_BODY_MAX_BYTES"); v != "" {
+		var err error
+		if logBodyMax, err = strconv.Atoi(v); err != nil || logBodyMax < 0 {
+			log.Fatalf("Invalid LOG_BODY_MAX_BYTES: %q", v)
+		}
+	}
+	requestLog := newLogPipeline(logQueueSize, logWorkers, os.Getenv("LOG_QUEUE_FULL") != "drop", logBodyMax)
+
+	// REQUEST_TIMEOUT bounds delays and hanging mocks; requests still waiting
+	// when it passes get a 503 and are logged as server timeouts. It wraps the
+	// logging middleware so the log can tell a timeout from a client abort.
+	if v := os.Getenv("REQUEST_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			log.Fatalf("Invalid REQUEST_TIMEOUT: %q", v)
+		}
+		r.Use(timeoutMiddleware(timeout))
+	}
 
-	// Define routes based on OpenAPI specification
-	// Users endpoints
-	r.HandleFunc("/users", serveStaticJSON("users.json")).Methods("GET")
-	r.HandleFunc("/users", serveStaticJSON("user.json")).Methods("POST")
-	r.HandleFunc("/users/{id}", serveStaticJSON("user.json")).Methods("GET")
-	r.HandleFunc("/users/{id}", serveStaticJSON("user.json")).Methods("PUT")
-	r.HandleFunc("/users/{id}", handleDelete).Methods("DELETE")
+	// CLIENTS_FILE holds IP allow/deny lists and per-caller profiles (response
+	// layer, latency, faults, rate limit). A missing default file is not an error.
+	clientsFile := os.Getenv("CLIENTS_FILE")
+	if clientsFile == "" {
+		clientsFile = "clients.json"
+	}
+	clients, err := loadClientConfig(clientsFile)
+	switch {
+	case err == nil:
+		log.Printf("Loaded %d client profile(s), %d allow and %d deny rule(s) from %s", len(clients.Profiles), len(clients.allow), len(clients.deny), clientsFile)
+	case os.IsNotExist(err) && os.Getenv("CLIENTS_FILE") == "":
+	default:
+		log.Fatalf("Failed to load client profiles from %s: %v", clientsFile, err)
+	}
+	r.Use(requestLog.middleware)
+	if clients != nil {
+		r.Use(clients.enforce)
+	}
 
-	// Products endpoints
-	r.HandleFunc("/products", serveStaticJSON("products.json")).Methods("GET")
-	r.HandleFunc("/products/{id}", serveStaticJSON("product.json")).Methods("GET")
+	// Prefer: code=404, example=notFound, dynamic=true answers any operation documented
+	// in OPENAPI_SPEC with that response instead of the usual handler.
+	specFile := os.Getenv("OPENAPI_SPEC")
+	if specFile == "" {
+		specFile = "openapi.yaml"
+	}
+	spec, err := loadOpenAPISpec(specFile)
+	switch {
+	case err == nil:
+		for _, warning := range spec.warnings {
+			log.Printf("WARNING: %s: %s", specFile, warning)
+		}
+		r.Use(spec.preferMiddleware)
+	case os.IsNotExist(err) && os.Getenv("OPENAPI_SPEC") == "":
+	default:
+		log.Fatalf("Failed to load OpenAPI spec from %s: %v", specFile, err)
+	}
 
-	// Orders endpoints
-	r.HandleFunc("/orders", serveStaticJSON("order.json")).Methods("POST")
+	// Runtime mocks added through the admin API take precedence over the routes below
+	r.Use(runtimeMockMiddleware)
 
-	// Health check endpoint (not in OpenAPI but useful)
-	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status":    "healthy",
-			"timestamp": time.Now().Format(time.RFC3339),
-			"server":    "dummy-logger-go-server",
-		})
-	}).Methods("GET")
+	// Response files are cached in memory and revalidated by modification time at most
+	// every RESPONSE_CACHE_CHECK, so edits are picked up without a restart.
+	cacheCheck := time.Second
+	if v := os.Getenv("RESPONSE_CACHE_CHECK"); v != "" {
+		var err error
+		if cacheCheck, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid RESPONSE_CACHE_CHECK: %v", err)
+		}
+	}
+	cacheMax := int64(64 << 20)
+	if v := os.Getenv("RESPONSE_CACHE_MAX_BYTES"); v != "" {
+		var err error
+		if cacheMax, err = strconv.ParseInt(v, 10, 64); err != nil || cacheMax < 0 {
+			log.Fatalf("Invalid RESPONSE_CACHE_MAX_BYTES: %q", v)
+		}
+	}
+	files := newResponseCache(os.Getenv("RESPONSE_CACHE") != "false", cacheCheck, cacheMax)
+	// RESPONSE_LAYERS stacks response directories (base=responses,staging=overlays/staging),
+	// higher layers overriding files by path; requests can pick the layers stacked on base with
+	// RESPONSE_LAYER_HEADER.
+	responsesDir := os.Getenv("RESPONSES_DIR")
+	if responsesDir == "" {
+		responsesDir = "responses"
+	}
+	layers := []responseLayer{{Name: "base", Dir: responsesDir}}
+	if spec := os.Getenv("RESPONSE_LAYERS"); spec != "" {
+		var err error
+		if layers, err = parseResponseLayers(spec); err != nil {
+			log.Fatalf("Invalid RESPONSE_LAYERS: %v", err)
+		}
+		responsesDir = layers[0].Dir
+	}
+	if clients != nil {
+		if err := clients.checkLayers(layers); err != nil {
+			log.Fatalf("Invalid %s: %v", clientsFile, err)
+		}
+	}
+	layerHeader := os.Getenv("RESPONSE_LAYER_HEADER")
+	if layerHeader == "" {
+		layerHeader = "X-Mock-Layer"
+	}
+	if os.Getenv("RESPONSE_CACHE_PRELOAD") == "true" {
+		for _, layer := range layers {
+			n, err := files.preload(layer.Dir)
+			if err != nil {
+				log.Fatalf("Failed to preload response files: %v", err)
+			}
+			log.Printf("Preloaded %d response file(s) from layer %s into the cache", n, layer.Name)
+		}
+	}
 
-	// Echo endpoint - returns only the request body
-	r.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
-		// Read the request body
-		body, err := io.ReadAll(r.Body)
+	// Stateful mode keeps records in memory, seeded from the response files,
+	// and serves CRUD plus nested routes for the relations in STORE_CONFIG.
+	stateful := os.Getenv("STATEFUL") == "true"
+	var dataStore *store
+	if stateful {
+		storeConfig := "store.json"
+		if envConfig := os.Getenv("STORE_CONFIG"); envConfig != "" {
+			storeConfig = envConfig
+		}
+		var err error
+		dataStore, err = loadStore(storeConfig, responsesDir)
 		if err != nil {
-			log.Printf("Error reading request body for echo: %v", err)
-			http.Error(w, "Error reading request body", http.StatusBadRequest)
-			return
+			log.Fatalf("Failed to load stateful store from %s: %v", storeConfig, err)
 		}
 
+		// Business rules are optional; a missing default rules file is not an error.
+		rulesFile := os.Getenv("RULES_FILE")
+		if rulesFile == "" {
+			rulesFile = "rules.json"
+		}
+		rules, err := loadRules(rulesFile, dataStore)
+		switch {
+		case err == nil:
+			dataStore.rules = rules
+			log.Printf("Loaded business rules for %d collection(s) from %s", len(rules), rulesFile)
+		case os.IsNotExist(err) && os.Getenv("RULES_FILE") == "":
+		default:
+			log.Fatalf("Failed to load business rules from %s: %v", rulesFile, err)
+		}
+
+		// Fixture files (<collection>.csv/.yaml/.json) add records on top of the seeds.
+		fixturesDir := os.Getenv("FIXTURES_DIR")
+		if fixturesDir == "" {
+			fixturesDir = "fixtures"
+		}
+		loaded, err := dataStore.loadFixtures(fixturesDir)
+		switch {
+		case err == nil:
+			for name, n := range loaded {
+				log.Printf("Loaded %d fixture record(s) into %s from %s", n, name, fixturesDir)
+			}
+		case os.IsNotExist(err) && os.Getenv("FIXTURES_DIR") == "":
+		default:
+			log.Fatalf("Failed to load fixtures from %s: %v", fixturesDir, err)
+		}
+
+		// GENERATE="users=1000,orders=5000" adds fake records; GENERATE_SEED makes them reproducible.
+		if spec := os.Getenv("GENERATE"); spec != "" {
+			counts, err := parseGenerateCounts(spec)
+			if err != nil {
+				log.Fatalf("Invalid GENERATE: %v", err)
+			}
+			seed := int64(1)
+			if envSeed := os.Getenv("GENERATE_SEED"); envSeed != "" {
+				if seed, err = strconv.ParseInt(envSeed, 10, 64); err != nil {
+					log.Fatalf("Invalid GENERATE_SEED: %v", err)
+				}
+			}
+			if err := dataStore.generate(counts, seed); err != nil {
+				log.Fatalf("Failed to generate records: %v", err)
+			}
+			log.Printf("Generated records %s with seed %d", spec, seed)
+		}
+		registerCollectionRoutes(r, dataStore.collectionNames())
+		state.register("store", dataStore)
+	}
+
+	// Echo endpoint - returns only the request body
+	r.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		// The body was already read by the logging middleware
+		body := bodyFrom(r)
+
 		// If body is JSON, try to parse and return as JSON
-		if strings.Contains(r.Header.Get("Content-Type"), "application/json") && len(body) > 0 {
-			var jsonBody interface{}
-			if err := json.Unmarshal(body, &jsonBody); err == nil {
+		if strings.Contains(r.Header.Get("Content-Type"), "application/json") && len(body.raw) > 0 {
+			if jsonBody, err := body.json(); err == nil {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusOK)
 				json.NewEncoder(w).Encode(jsonBody)
@@ -348,14 +416,12 @@ func main() {
 		// For non-JSON or invalid JSON, return the raw body
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusOK)
-		w.Write(body)
+		w.Write(body.raw)
 	})
 
 	// Error simulation endpoints
 	r.HandleFunc("/error/404", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("X-Served-By", "dummy-logger-server")
-		w.Header().Set("X-Timestamp", time.Now().Format(time.RFC3339))
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"error":   "Not Found",
@@ -367,8 +433,6 @@ func main() {
 
 	r.HandleFunc("/error/500", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("X-Served-By", "dummy-logger-server")
-		w.Header().Set("X-Timestamp", time.Now().Format(time.RFC3339))
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"error":   "Internal Server Error",
@@ -378,34 +442,285 @@ func main() {
 		})
 	}).Methods("GET", "POST", "PUT", "DELETE")
 
+	// Holds the request open until the client gives up (or REQUEST_TIMEOUT passes)
+	r.HandleFunc("/hang", hangHandler)
+
+	// Namespaces isolate stored data and captured requests per test session.
+	// They are selected by NAMESPACE_HEADER or a /__ns/{name}/ path prefix.
+	namespaceHeader := os.Getenv("NAMESPACE_HEADER")
+	if namespaceHeader == "" {
+		namespaceHeader = "X-Mock-Session"
+	}
+	namespaceTTL := time.Hour
+	if envTTL := os.Getenv("NAMESPACE_TTL"); envTTL != "" {
+		var err error
+		if namespaceTTL, err = time.ParseDuration(envTTL); err != nil {
+			log.Fatalf("Invalid NAMESPACE_TTL: %v", err)
+		}
+	}
+	journalSize := 1000
+	if envSize := os.Getenv("JOURNAL_SIZE"); envSize != "" {
+		var err error
+		if journalSize, err = strconv.Atoi(envSize); err != nil || journalSize < 1 {
+			log.Fatalf("Invalid JOURNAL_SIZE: %q", envSize)
+		}
+	}
+	namespaces := newNamespaceManager(namespaceHeader, namespaceTTL, journalSize, dataStore)
+	state.register("namespaces", namespaces)
+	state.register("mocks", mocksStatePart{namespaces: namespaces})
+	state.register("counters", countersStatePart{namespaces: namespaces})
+	go namespaces.runExpiry()
+
+	// Health check endpoints (not in OpenAPI but useful): /health combines
+	// liveness and readiness for existing probes. They are served in front of
+	// the router, see below.
+	health := newHealthState(namespaces)
+	if logWorkers > 0 {
+		health.addCheck("log_queue", requestLog.check)
+	}
+	if spec != nil {
+		health.addCheck("spec", specFileCheck(specFile))
+	}
+
+	// The spec and interactive docs, with servers pointing at this listener.
+	// DOCS_ASSETS_URL serves the Swagger UI files (e.g. a local mirror when offline).
+	if spec != nil {
+		docsAssets := os.Getenv("DOCS_ASSETS_URL")
+		if docsAssets == "" {
+			docsAssets = "https://unpkg.com/swagger-ui-dist@5"
+		}
+		r.HandleFunc("/openapi.yaml", spec.handleYAML).Methods("GET")
+		r.HandleFunc("/openapi.json", spec.handleJSON).Methods("GET")
+		r.HandleFunc("/docs", spec.handleDocs(docsAssets)).Methods("GET")
+	}
+
+	// Admin API for snapshots, namespaces, captured requests and health state.
+	// It has its own listener (ADMIN_ADDR or ADMIN_SOCKET) so the client under
+	// test cannot reach it.
+	snapshotDir := os.Getenv("SNAPSHOT_DIR")
+	if snapshotDir == "" {
+		snapshotDir = "snapshots"
+	}
+	adminCfg, err := adminConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid admin configuration: %v", err)
+	}
+	audit, err := newAuditLog(adminCfg.auditMax, adminCfg.auditLog)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	admin := &adminAPI{state: state, namespaces: namespaces, health: health, snapshotDir: snapshotDir, config: adminCfg, audit: audit, logs: requestLog, cache: files}
+
+	// Start from a known baseline when a snapshot is configured
+	if snapshotFile := os.Getenv("SNAPSHOT_FILE"); snapshotFile != "" {
+		if err := state.loadFile(snapshotFile); err != nil {
+			log.Fatalf("Failed to restore snapshot %s: %v", snapshotFile, err)
+		}
+		namespaces.rebase()
+		log.Printf("Restored state from snapshot %s", snapshotFile)
+	}
+
+	// Response files routed by directory convention (RESPONSES_DIR/users/{id}/GET.json).
+	// Built-in and collection routes above take precedence.
+	fileRoutes := newFileRouter(layers, layerHeader, files, cacheCheck)
+	// Without RESPONSES_DIR or RESPONSE_LAYERS a missing ./responses just
+	// means no response files are used
+	if _, err := os.Stat(responsesDir); err == nil || os.Getenv("RESPONSES_DIR") != "" || os.Getenv("RESPONSE_LAYERS") != "" {
+		health.addCheck("responses", fileRoutes.check)
+	}
+	r.MatcherFunc(fileRoutes.match).HandlerFunc(fileRoutes.serve)
+
 	// Catch-all handler for unmatched routes (must be last)
 	r.PathPrefix("/").HandlerFunc(catchAllHandler)
 
-	// Start server
-	port := "8080"
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		port = envPort
+	// Headers added to every response: X-Served-By and X-Timestamp, plus the
+	// defaults, security preset, Server header and per-route headers of
+	// HEADERS_FILE. SECURITY_HEADERS=true and SERVER_HEADER=name work without a file.
+	headersFile := os.Getenv("HEADERS_FILE")
+	if headersFile == "" {
+		headersFile = "headers.json"
+	}
+	headers, err := loadHeaderConfig(headersFile)
+	switch {
+	case err == nil:
+		log.Printf("Loaded response headers from %s (%d route rule(s))", headersFile, len(headers.Routes))
+	case os.IsNotExist(err) && os.Getenv("HEADERS_FILE") == "":
+		headers = defaultHeaderConfig()
+	default:
+		log.Fatalf("Failed to load response headers from %s: %v", headersFile, err)
+	}
+	if os.Getenv("SECURITY_HEADERS") == "true" {
+		headers.Security = true
+	}
+	if server := os.Getenv("SERVER_HEADER"); server != "" {
+		headers.Server = server
 	}
 
-	log.Printf("Starting dummy logger server on port %s", port)
-	log.Printf("Server will log all incoming requests extensively")
-	log.Printf("Static JSON responses are served from the 'responses' directory")
+	// Start server: PORT, a unix SOCKET and systemd-activated sockets
+	listeners, err := listenersFromEnv()
+	if err != nil {
+		log.Fatal("Server failed to start:", err)
+	}
+
+	for _, ln := range listeners {
+		log.Printf("Starting dummy logger server on %s", ln.name)
+	}
+	log.Printf("Server will log all incoming requests extensively (%d log worker(s))", logWorkers)
+	if len(layers) > 1 {
+		var stack []string
+		for _, layer := range layers {
+			stack = append(stack, layer.Name+"="+layer.Dir)
+		}
+		log.Printf("Response files are served from layers %s (select per request with %s)", strings.Join(stack, " -> "), layerHeader)
+	} else {
+		log.Printf("Response files are served from the '%s' directory", responsesDir)
+	}
 	log.Println("Available endpoints:")
-	log.Println("  GET    /users")
-	log.Println("  POST   /users")
-	log.Println("  GET    /users/{id}")
-	log.Println("  PUT    /users/{id}")
-	log.Println("  DELETE /users/{id}")
-	log.Println("  GET    /products")
-	log.Println("  GET    /products/{id}")
-	log.Println("  POST   /orders")
-	log.Println("  GET    /health")
+	if stateful {
+		for _, name := range dataStore.collectionNames() {
+			log.Printf("  GET    /%s", name)
+			log.Printf("  POST   /%s", name)
+			log.Printf("  GET    /%s/{id}", name)
+			log.Printf("  PUT    /%s/{id}", name)
+			log.Printf("  PATCH  /%s/{id}", name)
+			log.Printf("  DELETE /%s/{id}", name)
+		}
+		for _, rel := range dataStore.relations {
+			log.Printf("  GET    /%s/{id}/%s (nested via %s.%s)", rel.Target, rel.Collection, rel.Collection, rel.Field)
+		}
+	}
+	fileRoutes.logTable()
+	log.Println("  GET    /health, /health/live, /health/ready")
 	log.Println("  *      /echo     (returns what it receives)")
 	log.Println("  *      /error/404 (simulates 404 Not Found)")
 	log.Println("  *      /error/500 (simulates 500 Internal Server Error)")
+	log.Println("  *      /hang      (never answers; ?headers=true sends headers first)")
+	if spec != nil {
+		log.Println("  GET    /openapi.yaml, /openapi.json, /docs (API spec and interactive docs)")
+		log.Printf("  Prefer: code=, example=, dynamic=true on the %d operation(s) in %s (%s)", len(spec.operations), specFile, spec.describe())
+	}
+	log.Printf("Admin API on %s:", adminCfg.describe())
+	log.Println("  GET    /__admin/snapshot  (current mock state)")
+	log.Println("  POST   /__admin/snapshot?file=NAME (save state to the snapshot directory)")
+	log.Println("  POST   /__admin/restore   (restore a snapshot body or ?file=NAME)")
+	log.Println("  GET    /__admin/namespaces, DELETE /__admin/namespaces/{name}")
+	log.Println("  GET    /__admin/requests, DELETE /__admin/requests (captured requests of the namespace)")
+	log.Println("  GET    /__admin/requests/{id}/raw (a request exactly as received, with RAW_CAPTURE=true)")
+	log.Println("  POST   /__admin/verify, GET /__admin/export, POST /__admin/reset")
+	log.Println("  GET    /__admin/mocks, POST /__admin/mocks, DELETE /__admin/mocks[/{id}]")
+	log.Println("  GET    /__admin/health, PUT /__admin/health, DELETE /__admin/health (force health state)")
+	log.Println("  GET    /__admin/audit     (admin changes: who, what, when, before/after)")
+	log.Println("  GET    /__admin/cache, DELETE /__admin/cache (response file cache statistics)")
+	log.Println("  GET    /__admin/clock, PUT /__admin/clock, DELETE /__admin/clock (set, advance or freeze the mock clock)")
+	log.Println("  GET    /__admin/debug/pprof/, /__admin/debug/stats, POST /__admin/debug/gc (runtime diagnostics)")
+	log.Printf("Namespaces: %s header or /__ns/{name}/ prefix, idle TTL %v", namespaceHeader, namespaceTTL)
+	log.Printf("Version %s, readiness checks: %s", version, strings.Join(health.checkNames(), ", "))
+	if deterministic {
+		log.Printf("Deterministic mode: clock frozen at %s, seed %d", clock.Now().Format(time.RFC3339), deterministicSeed)
+	}
 	log.Println()
 
-	if err := http.ListenAndServe(":"+port, r); err != nil {
-		log.Fatal("Server failed to start:", err)
+	if adminCfg.exposedWithoutAuth() {
+		log.Printf("WARNING: admin API on %s has no authentication; set ADMIN_TOKEN or ADMIN_TLS_CLIENT_CA", adminCfg.addr)
+	}
+	// RUNTIME_STATS_INTERVAL=1m logs memory, GC and buffer sizes periodically (useful in soak tests)
+	if envInterval := os.Getenv("RUNTIME_STATS_INTERVAL"); envInterval != "" {
+		interval, err := time.ParseDuration(envInterval)
+		if err != nil || interval <= 0 {
+			log.Fatalf("Invalid RUNTIME_STATS_INTERVAL: %q", envInterval)
+		}
+		go admin.logRuntimeStats(interval)
+	}
+	go func() {
+		if err := serveAdmin(adminCfg, admin.handler()); err != nil {
+			log.Fatal("Admin server failed to start:", err)
+		}
+	}()
+
+	var routed http.Handler = r
+	if clients != nil {
+		// Identified before routing so the response file matcher serves the
+		// profile's layer
+		routed = clients.identify(r)
+	}
+	// Probes bypass client allow lists and rate limits
+	routed = health.middleware(routed)
+	handler := clock.middleware(namespaces.middleware(headers.middleware(routed)))
+	// RAW_CAPTURE=true records the exact bytes of each HTTP/1.x request, up to
+	// RAW_CAPTURE_MAX_BYTES, in the log and the journal.
+	rawCapture := os.Getenv("RAW_CAPTURE") == "true"
+	rawMax := 64 << 10
+	if rawCapture {
+		if v := os.Getenv("RAW_CAPTURE_MAX_BYTES"); v != "" {
+			var err error
+			if rawMax, err = strconv.Atoi(v); err != nil || rawMax < 0 {
+				log.Fatalf("Invalid RAW_CAPTURE_MAX_BYTES: %q", v)
+			}
+		}
+		handler = rawCaptureMiddleware(handler)
+		log.Printf("Raw capture enabled (up to %d bytes per request)", rawMax)
+	}
+
+	// LOG_CONNECTIONS=true logs connections opening and closing and adds a
+	// timing breakdown (headers, body, handler) to every request and the time
+	// spent writing each response to the connection.
+	logConnections := os.Getenv("LOG_CONNECTIONS") == "true"
+	connContext := func(ctx context.Context, c net.Conn) context.Context {
+		return socketPeerContext(connInfoContext(captureConnContext(ctx, c), c), c)
+	}
+
+	// TRUSTED_PROXIES (CIDRs or addresses) are believed when they forward the
+	// client address in FORWARDED_HEADER (X-Forwarded-For by default, or
+	// Forwarded or X-Real-IP); PROXY_PROTOCOL=true expects an HAProxy PROXY
+	// header (v1 or v2) from them.
+	if v := os.Getenv("FORWARDED_HEADER"); v != "" {
+		var err error
+		if forwardedHeader, err = parseForwardedHeader(v); err != nil {
+			log.Fatalf("Invalid FORWARDED_HEADER: %v", err)
+		}
+	}
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		var err error
+		if proxies, err = parseIPNetworks(v); err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+		}
+		log.Printf("Trusting client addresses in %s from %s", forwardedHeader, v)
+	}
+	proxyProtocol := os.Getenv("PROXY_PROTOCOL") == "true"
+	proxyHeaderTimeout := 5 * time.Second
+	if v := os.Getenv("PROXY_HEADER_TIMEOUT"); v != "" {
+		var err error
+		if proxyHeaderTimeout, err = time.ParseDuration(v); err != nil || proxyHeaderTimeout <= 0 {
+			log.Fatalf("Invalid PROXY_HEADER_TIMEOUT: %q", v)
+		}
+	}
+
+	if proxyProtocol {
+		if len(proxies) > 0 {
+			log.Println("PROXY protocol header required from trusted proxies")
+		} else {
+			log.Println("PROXY protocol header required on every connection")
+		}
+	}
+
+	// Every listener gets its own server so requests know which one they came in on
+	errs := make(chan error, len(listeners))
+	for _, ml := range listeners {
+		srv := &http.Server{Handler: handler, ConnContext: withListener(ml.name, connContext)}
+		var ln net.Listener = ml.Listener
+		if proxyProtocol {
+			ln = newProxyListener(ln, proxies, proxyHeaderTimeout)
+		}
+		if logConnections {
+			srv.ConnState = connState
+			ln = timingListener{Listener: ln, name: ml.name}
+		}
+		if rawCapture {
+			ln = captureListener{Listener: ln, max: rawMax}
+		}
+		go func() {
+			errs <- srv.Serve(ln)
+		}()
 	}
+	log.Fatal("Server failed: ", <-errs)
 }
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// mockDef is a response defined at runtime through the admin API. It takes
+// precedence over the built-in routes of its namespace.
+type mockDef struct {
+	ID string `json:"id,omitempty"`
+	// Method matches the request method; empty or "*" matches any.
+	Method string `json:"method,omitempty"`
+	// Path matches segment by segment: "{name}" matches any single segment and a
+	// trailing "*" matches the rest of the path.
+	Path    string            `json:"path"`
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is written as-is when it is a JSON string, otherwise as JSON.
+	Body json.RawMessage `json:"body,omitempty"`
+	// BodyBase64 marks a JSON string body holding base64-encoded binary content.
+	BodyBase64 bool `json:"body_base64,omitempty"`
+	DelayMs    int  `json:"delay_ms,omitempty"`
+	// Hang never answers: the request is held until the client gives up.
+	Hang bool  `json:"hang,omitempty"`
+	Hits int64 `json:"hits"`
+}
+
+// mockSet holds the runtime mocks of one namespace, newest first.
+type mockSet struct {
+	mu    sync.RWMutex
+	mocks []*mockDef
+	seq   int // number of the last generated id
+}
+
+func (ms *mockSet) add(m mockDef) (mockDef, error) {
+	added, err := ms.addAll([]mockDef{m})
+	if err != nil {
+		return mockDef{}, err
+	}
+	return added[0], nil
+}
+
+// addAll validates every definition before adding any, then adds them all
+// under one lock, so a bad definition leaves the set unchanged. It returns
+// copies of the added mocks.
+func (ms *mockSet) addAll(defs []mockDef) ([]mockDef, error) {
+	for i := range defs {
+		if err := defs[i].normalize(); err != nil {
+			if len(defs) > 1 {
+				return nil, fmt.Errorf("mock %d: %w", i+1, err)
+			}
+			return nil, err
+		}
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	added := make([]mockDef, 0, len(defs))
+	for i := range defs {
+		m := defs[i]
+		if m.ID == "" {
+			ms.seq++
+			m.ID = fmt.Sprintf("mock-%d", ms.seq)
+		} else {
+			ms.removeLocked(m.ID)
+		}
+		ms.mocks = append([]*mockDef{&m}, ms.mocks...)
+		added = append(added, m)
+	}
+	return added, nil
+}
+
+// normalize checks a definition and fills in its defaults.
+func (m *mockDef) normalize() error {
+	if m.Path == "" || !strings.HasPrefix(m.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	if m.Status == 0 {
+		m.Status = http.StatusOK
+	}
+	if m.Status < 100 || m.Status > 999 {
+		return fmt.Errorf("invalid status %d", m.Status)
+	}
+	if len(m.Body) > 0 && !json.Valid(m.Body) {
+		return fmt.Errorf("body must be valid JSON")
+	}
+	m.Method = strings.ToUpper(m.Method)
+	m.Hits = 0
+	return nil
+}
+
+func (ms *mockSet) remove(id string) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.removeLocked(id)
+}
+
+func (ms *mockSet) removeLocked(id string) bool {
+	for i, m := range ms.mocks {
+		if m.ID == id {
+			ms.mocks = append(ms.mocks[:i], ms.mocks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// clear drops every mock and returns how many there were.
+func (ms *mockSet) clear() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	dropped := len(ms.mocks)
+	ms.mocks = nil
+	return dropped
+}
+
+// reset drops every mock and makes the next generated id mock-1 again.
+func (ms *mockSet) reset() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	dropped := len(ms.mocks)
+	ms.mocks, ms.seq = nil, 0
+	return dropped
+}
+
+func (ms *mockSet) list() []mockDef {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	out := make([]mockDef, 0, len(ms.mocks))
+	for _, m := range ms.mocks {
+		out = append(out, m.copy())
+	}
+	return out
+}
+
+func (ms *mockSet) len() int {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return len(ms.mocks)
+}
+
+// replace swaps in a full set of definitions, e.g. from a snapshot.
+func (ms *mockSet) replace(defs []mockDef) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.mocks = make([]*mockDef, 0, len(defs))
+	for i := range defs {
+		m := defs[i]
+		ms.mocks = append(ms.mocks, &m)
+	}
+	ms.setSeqLocked(ms.seq)
+}
+
+// sequence is the number of the last generated id.
+func (ms *mockSet) sequence() int {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.seq
+}
+
+// restoreSequence continues the numbering from a snapshot.
+func (ms *mockSet) restoreSequence(n int) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.setSeqLocked(n)
+}
+
+// setSeqLocked sets the last generated id to n, or to the highest mock-N
+// already present, so a generated id never repeats a live one.
+func (ms *mockSet) setSeqLocked(n int) {
+	for _, m := range ms.mocks {
+		if rest, ok := strings.CutPrefix(m.ID, "mock-"); ok {
+			if id, err := strconv.Atoi(rest); err == nil && id > n {
+				n = id
+			}
+		}
+	}
+	ms.seq = n
+}
+
+func (ms *mockSet) match(r *http.Request) *mockDef {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, m := range ms.mocks {
+		if (m.Method == "" || m.Method == "*" || m.Method == r.Method) && pathMatches(m.Path, r.URL.Path) {
+			return m
+		}
+	}
+	return nil
+}
+
+func pathMatches(pattern, path string) bool {
+	pp := strings.Split(strings.Trim(pattern, "/"), "/")
+	sp := strings.Split(strings.Trim(path, "/"), "/")
+	for i, seg := range pp {
+		if seg == "*" && i == len(pp)-1 {
+			return true
+		}
+		if i >= len(sp) {
+			return false
+		}
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			continue
+		}
+		if seg != sp[i] {
+			return false
+		}
+	}
+	return len(pp) == len(sp)
+}
+
+// copy returns the definition with its hit count read atomically, since
+// serve updates it while the mock is in use.
+func (m *mockDef) copy() mockDef {
+	return mockDef{
+		ID:         m.ID,
+		Method:     m.Method,
+		Path:       m.Path,
+		Status:     m.Status,
+		Headers:    m.Headers,
+		Body:       m.Body,
+		BodyBase64: m.BodyBase64,
+		DelayMs:    m.DelayMs,
+		Hang:       m.Hang,
+		Hits:       atomic.LoadInt64(&m.Hits),
+	}
+}
+
+// serve writes the mock's response.
+func (m *mockDef) serve(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&m.Hits, 1)
+	if m.Hang {
+		hangHandler(w, r)
+		return
+	}
+	if m.DelayMs > 0 && !wait(r, time.Duration(m.DelayMs)*time.Millisecond) {
+		abandon(w, r)
+		return
+	}
+	var text string
+	isText := json.Unmarshal(m.Body, &text) == nil
+	if isText && m.BodyBase64 {
+		if data, err := base64.StdEncoding.DecodeString(text); err == nil {
+			text = string(data)
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+	} else if isText {
+		w.Header().Set("Content-Type", "text/plain")
+	} else if len(m.Body) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	for k, v := range m.Headers {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(m.Status)
+	if isText {
+		w.Write([]byte(text))
+	} else {
+		w.Write(m.Body)
+	}
+}
+
+// runtimeMockMiddleware answers requests matching a runtime mock of the
+// request's namespace before they reach the regular routes.
+func runtimeMockMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ns := namespaceFrom(r)
+		if ns != nil {
+			if m := ns.mocks.match(r); m != nil {
+				log.Printf("Serving runtime mock %s (%s %s)", m.ID, m.Method, m.Path)
+				m.serve(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mocksState is the snapshot form of every namespace's runtime mocks, keyed by namespace name.
+type mocksState map[string][]mockDef
+
+// mocksStatePart captures runtime mocks across all namespaces.
+type mocksStatePart struct {
+	namespaces *namespaceManager
+}
+
+func (p mocksStatePart) snapshotState() (interface{}, error) {
+	state := make(mocksState)
+	for _, ns := range p.namespaces.all() {
+		if defs := ns.mocks.list(); len(defs) > 0 {
+			state[ns.name] = defs
+		}
+	}
+	return state, nil
+}
+
+func (p mocksStatePart) prepareState(data json.RawMessage) (func(), error) {
+	var state mocksState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	for name := range state {
+		if name != "" && !namespaceNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid namespace %q", name)
+		}
+	}
+	return func() {
+		for _, ns := range p.namespaces.all() {
+			ns.mocks.replace(state[ns.name])
+			delete(state, ns.name)
+		}
+		for name, defs := range state {
+			p.namespaces.get(name).mocks.replace(defs)
+		}
+	}, nil
+}
+
+// mocksFromJournal turns captured exchanges into mock definitions, keeping the
+// latest exchange for each method and path.
+func mocksFromJournal(entries []journalEntry) []mockDef {
+	seen := make(map[string]int)
+	out := make([]mockDef, 0)
+	for _, e := range entries {
+		def := mockDef{
+			Method: e.Method,
+			Path:   e.Path,
+			Status: e.Status,
+		}
+		if ct := firstHeader(e.ResponseHeaders, "Content-Type"); ct != "" {
+			def.Headers = map[string]string{"Content-Type": ct}
+		}
+		if e.ResponseBodyTruncated {
+			log.Printf("Exporting %s %s without its response body, which was captured truncated", e.Method, e.Path)
+		} else if e.ResponseBodyBase64 {
+			def.Body, _ = json.Marshal(string(e.ResponseBody))
+			def.BodyBase64 = true
+		} else if json.Valid(e.ResponseBody) {
+			def.Body = json.RawMessage(e.ResponseBody)
+		} else if len(e.ResponseBody) > 0 {
+			def.Body, _ = json.Marshal(string(e.ResponseBody))
+		}
+		key := e.Method + " " + e.Path
+		if i, ok := seen[key]; ok {
+			out[i] = def
+			continue
+		}
+		seen[key] = len(out)
+		out = append(out, def)
+	}
+	return out
+}
+
+func firstHeader(h map[string][]string, name string) string {
+	if values := h[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
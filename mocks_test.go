@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestPathMatches(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/users", "/users", true},
+		{"/users", "/users/", true},
+		{"/users", "/orders", false},
+		{"/users", "/users/1", false},
+		{"/users/{id}", "/users/1", true},
+		{"/users/{id}", "/users", false},
+		{"/users/{id}/orders", "/users/1/orders", true},
+		{"/users/{id}/orders", "/users/1/carts", false},
+		{"/users/*", "/users/1/orders/2", true},
+		{"/users/*", "/users", true}, // a trailing * matches zero or more segments
+		{"/users/*/orders", "/users/*/orders", true},
+		{"/users/*/orders", "/users/1/orders", false},
+		{"/*", "/anything/at/all", true},
+		{"/", "/", true},
+	}
+	for _, tt := range tests {
+		if got := pathMatches(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("pathMatches(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMockSetAddAllIsAllOrNothing(t *testing.T) {
+	tests := []struct {
+		name  string
+		defs  []mockDef
+		added int
+	}{
+		{"all valid", []mockDef{{Path: "/a"}, {Path: "/b", Status: 404}}, 2},
+		{"bad path halfway", []mockDef{{Path: "/a"}, {Path: "b"}, {Path: "/c"}}, 0},
+		{"bad status last", []mockDef{{Path: "/a"}, {Path: "/b", Status: 42}}, 0},
+		{"bad body first", []mockDef{{Path: "/a", Body: []byte("{")}, {Path: "/b"}}, 0},
+	}
+	for _, tt := range tests {
+		var ms mockSet
+		_, err := ms.addAll(tt.defs)
+		if (err == nil) != (tt.added > 0) {
+			t.Errorf("%s: error %v", tt.name, err)
+		}
+		if n := ms.len(); n != tt.added {
+			t.Errorf("%s: %d mocks added, want %d", tt.name, n, tt.added)
+		}
+	}
+}
+
+func TestMockSetListDuringTraffic(t *testing.T) {
+	var ms mockSet
+	if _, err := ms.add(mockDef{Path: "/x"}); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r := httptest.NewRequest("GET", "/x", nil)
+				ms.match(r).serve(httptest.NewRecorder(), r)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		ms.list()
+	}
+	wg.Wait()
+	if hits := ms.list()[0].Hits; hits != 400 {
+		t.Errorf("hits %d, want 400", hits)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// namespacePathPrefix selects a namespace by path: /__ns/shard-3/users is
+// served as /users inside namespace "shard-3".
+const namespacePathPrefix = "/__ns/"
+
+var namespaceNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type namespaceContextKey struct{}
+
+// namespace isolates the mutable state of one test session: stored records,
+// runtime mocks and captured requests. The default namespace has an empty name.
+type namespace struct {
+	name     string
+	store    *store // nil unless STATEFUL=true
+	baseline *store // what reset restores when not the manager's baseline, set by rebase
+	journal  *journal
+	mocks    *mockSet
+	created  time.Time
+	mu       sync.Mutex
+	lastUsed time.Time
+}
+
+func (ns *namespace) touch() {
+	ns.mu.Lock()
+	ns.lastUsed = time.Now()
+	ns.mu.Unlock()
+}
+
+func (ns *namespace) idleSince() time.Time {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	return ns.lastUsed
+}
+
+// namespaceManager creates namespaces lazily from the startup baseline and
+// drops them after they have been idle for ttl.
+type namespaceManager struct {
+	header      string
+	ttl         time.Duration
+	journalSize int
+	baseline    *store
+
+	mu    sync.Mutex
+	def   *namespace
+	named map[string]*namespace
+}
+
+// newNamespaceManager uses defaultStore for requests without a namespace and a
+// copy of its current contents as the starting point of every new namespace.
+func newNamespaceManager(header string, ttl time.Duration, journalSize int, defaultStore *store) *namespaceManager {
+	m := &namespaceManager{
+		header:      header,
+		ttl:         ttl,
+		journalSize: journalSize,
+		named:       make(map[string]*namespace),
+	}
+	if defaultStore != nil {
+		m.baseline = defaultStore.clone()
+	}
+	m.def = &namespace{store: defaultStore, journal: newJournal(journalSize), mocks: &mockSet{}, created: time.Now(), lastUsed: time.Now()}
+	return m
+}
+
+// rebase makes the current contents of every namespace what a reset returns
+// it to, e.g. after a snapshot was restored. The default namespace's contents
+// are also the starting point of namespaces created from now on.
+func (m *namespaceManager) rebase() {
+	if m.def.store == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.baseline = m.def.store.clone()
+	for _, ns := range m.named {
+		if ns.store != nil {
+			ns.baseline = ns.store.clone()
+		}
+	}
+}
+
+// get returns the named namespace, creating it on first use. An empty name is the default namespace.
+func (m *namespaceManager) get(name string) *namespace {
+	if name == "" {
+		return m.def
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ns := m.named[name]
+	if ns == nil {
+		ns = &namespace{name: name, journal: newJournal(m.journalSize), mocks: &mockSet{}, created: time.Now(), lastUsed: time.Now()}
+		if m.baseline != nil {
+			ns.store = m.baseline.clone()
+		}
+		m.named[name] = ns
+		log.Printf("Created namespace %q", name)
+	}
+	return ns
+}
+
+// reset returns a namespace to the baseline: stored records are restored,
+// runtime mocks and captured requests are dropped and their ids start over.
+// It returns the counts the namespace held before and after, for the audit log.
+func (m *namespaceManager) reset(ns *namespace) (before, after map[string]interface{}) {
+	m.mu.Lock()
+	baseline := m.baseline
+	if ns.baseline != nil {
+		baseline = ns.baseline
+	}
+	m.mu.Unlock()
+	before, after = make(map[string]interface{}), make(map[string]interface{})
+	if ns.store != nil && baseline != nil {
+		before["records"], after["records"] = ns.store.resetTo(baseline)
+	}
+	before["mocks"], after["mocks"] = ns.mocks.reset(), 0
+	before["requests"], after["requests"] = ns.journal.reset(), 0
+	return before, after
+}
+
+// remove drops a named namespace and returns it, or nil if there is none.
+func (m *namespaceManager) remove(name string) *namespace {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ns := m.named[name]
+	delete(m.named, name)
+	return ns
+}
+
+// summary counts the records, mocks and captured requests of a namespace.
+func (ns *namespace) summary() map[string]interface{} {
+	out := map[string]interface{}{"mocks": ns.mocks.len(), "requests": ns.journal.len()}
+	if ns.store != nil {
+		out["records"] = ns.store.recordCounts()
+	}
+	return out
+}
+
+// summary counts what every namespace holds, keyed by name.
+func (m *namespaceManager) summary() map[string]interface{} {
+	out := make(map[string]interface{})
+	for _, ns := range m.all() {
+		out[ns.name] = ns.summary()
+	}
+	return out
+}
+
+// all returns the default namespace followed by the named ones sorted by name.
+func (m *namespaceManager) all() []*namespace {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []*namespace{m.def}
+	names := make([]string, 0, len(m.named))
+	for name := range m.named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		out = append(out, m.named[name])
+	}
+	return out
+}
+
+// expire removes namespaces idle for longer than the TTL.
+func (m *namespaceManager) expire(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, ns := range m.named {
+		if now.Sub(ns.idleSince()) > m.ttl {
+			delete(m.named, name)
+			log.Printf("Expired namespace %q after %v idle", name, m.ttl)
+		}
+	}
+}
+
+// runExpiry periodically drops idle namespaces. It returns immediately when TTL is disabled.
+func (m *namespaceManager) runExpiry() {
+	if m.ttl <= 0 {
+		return
+	}
+	interval := m.ttl / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	for now := range time.Tick(interval) {
+		m.expire(now)
+	}
+}
+
+// middleware resolves the namespace from the session header or the /__ns/{name}/
+// path prefix (which is stripped before routing) and stores it in the request context.
+func (m *namespaceManager) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(m.header)
+		if rest, ok := strings.CutPrefix(r.URL.Path, namespacePathPrefix); ok {
+			prefixName, path, _ := strings.Cut(rest, "/")
+			name = prefixName
+			r.URL.Path = "/" + path
+			r.URL.RawPath = ""
+		}
+		if name != "" && !namespaceNamePattern.MatchString(name) {
+			http.Error(w, fmt.Sprintf("invalid namespace %q", name), http.StatusBadRequest)
+			return
+		}
+		ns := m.get(name)
+		ns.touch()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), namespaceContextKey{}, ns)))
+	})
+}
+
+// namespaceFrom returns the namespace resolved for the request.
+func namespaceFrom(r *http.Request) *namespace {
+	ns, _ := r.Context().Value(namespaceContextKey{}).(*namespace)
+	return ns
+}
+
+// namespacesState is the snapshot form of the named namespaces' stored records.
+type namespacesState map[string]json.RawMessage
+
+func (m *namespaceManager) snapshotState() (interface{}, error) {
+	state := make(namespacesState)
+	for _, ns := range m.all()[1:] {
+		if ns.store == nil {
+			continue
+		}
+		v, err := ns.store.snapshotState()
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		state[ns.name] = data
+	}
+	return state, nil
+}
+
+func (m *namespaceManager) prepareState(data json.RawMessage) (func(), error) {
+	var state namespacesState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	decoded := make(map[string]map[string]*collection, len(state))
+	for name, storeData := range state {
+		if !namespaceNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid namespace %q", name)
+		}
+		collections, err := decodeStoreState(storeData)
+		if err != nil {
+			return nil, fmt.Errorf("namespace %q: %w", name, err)
+		}
+		decoded[name] = collections
+	}
+	return func() {
+		for name, collections := range decoded {
+			if ns := m.get(name); ns.store != nil {
+				ns.store.replaceCollections(collections)
+			}
+		}
+	}, nil
+}
+
+// namespaceCounters is the snapshot form of a namespace's id sequences.
+type namespaceCounters struct {
+	Requests int64 `json:"requests"`
+	Mocks    int   `json:"mocks"`
+}
+
+// countersStatePart captures the request and mock numbering of every
+// namespace, so ids continue after a restore as they would have.
+type countersStatePart struct {
+	namespaces *namespaceManager
+}
+
+func (p countersStatePart) snapshotState() (interface{}, error) {
+	state := make(map[string]namespaceCounters)
+	for _, ns := range p.namespaces.all() {
+		c := namespaceCounters{Requests: ns.journal.sequence(), Mocks: ns.mocks.sequence()}
+		if c.Requests > 0 || c.Mocks > 0 {
+			state[ns.name] = c
+		}
+	}
+	return state, nil
+}
+
+func (p countersStatePart) prepareState(data json.RawMessage) (func(), error) {
+	var state map[string]namespaceCounters
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	for name, c := range state {
+		if name != "" && !namespaceNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid namespace %q", name)
+		}
+		if c.Requests < 0 || c.Mocks < 0 {
+			return nil, fmt.Errorf("namespace %q: negative counter", name)
+		}
+	}
+	return func() {
+		for _, ns := range p.namespaces.all() {
+			c := state[ns.name]
+			ns.journal.restoreSequence(c.Requests)
+			ns.mocks.restoreSequence(c.Mocks)
+			delete(state, ns.name)
+		}
+		for name, c := range state {
+			ns := p.namespaces.get(name)
+			ns.journal.restoreSequence(c.Requests)
+			ns.mocks.restoreSequence(c.Mocks)
+		}
+	}, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+func TestNamespaceIDsArePerNamespaceAndRestartOnReset(t *testing.T) {
+	m := newNamespaceManager("X-Mock-Session", 0, 10, nil)
+	a, b := m.get("a"), m.get("b")
+	for i := 0; i < 3; i++ {
+		a.journal.add(journalEntry{})
+	}
+	b.journal.add(journalEntry{})
+	if _, err := a.mocks.add(mockDef{Path: "/x"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.journal.list()[0].ID; got != 1 {
+		t.Errorf("first request of b: id %d, want 1", got)
+	}
+	if got := a.journal.list()[2].ID; got != 3 {
+		t.Errorf("third request of a: id %d, want 3", got)
+	}
+
+	m.reset(a)
+	a.journal.add(journalEntry{})
+	mock, err := a.mocks.add(mockDef{Path: "/x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.journal.list()[0].ID; got != 1 {
+		t.Errorf("first request of a after reset: id %d, want 1", got)
+	}
+	if mock.ID != "mock-1" {
+		t.Errorf("first mock of a after reset: id %s, want mock-1", mock.ID)
+	}
+	b.journal.add(journalEntry{})
+	if got := b.journal.list()[1].ID; got != 2 {
+		t.Errorf("second request of b: id %d, want 2 (b was not reset)", got)
+	}
+}
+
+func TestClearKeepsNumbering(t *testing.T) {
+	m := newNamespaceManager("X-Mock-Session", 0, 10, nil)
+	ns := m.get("a")
+	first, err := ns.mocks.add(mockDef{Path: "/x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.mocks.clear()
+	ns.journal.add(journalEntry{})
+	ns.journal.clear()
+	ns.journal.add(journalEntry{})
+	second, err := ns.mocks.add(mockDef{Path: "/x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == second.ID {
+		t.Errorf("mock added after clear reused id %s", second.ID)
+	}
+	if got := ns.journal.list()[0].ID; got != 2 {
+		t.Errorf("request captured after clear: id %d, want 2", got)
+	}
+}
+
+func TestAuditIDsAreUnique(t *testing.T) {
+	l, err := newAuditLog(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ns := range []string{"", "a", "b", "a"} {
+		l.add(auditEntry{Namespace: ns})
+	}
+	for i, e := range l.list() {
+		if e.ID != int64(i+1) {
+			t.Errorf("entry %d: id %d, want %d", i, e.ID, i+1)
+		}
+	}
+}
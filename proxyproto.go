@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener accepts connections that start with an HAProxy PROXY protocol
+// header (v1 text or v2 binary) and reports the address it carries as the
+// connection's remote address. Only peers in TRUSTED_PROXIES may send one
+// (any peer when no proxies are configured); connections from other peers
+// are served as they are. Headers are read off the accept loop so a slow
+// peer cannot hold up other connections.
+type proxyListener struct {
+	net.Listener
+	trusted ipNetworks
+	timeout time.Duration
+
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newProxyListener(ln net.Listener, trusted ipNetworks, timeout time.Duration) *proxyListener {
+	l := &proxyListener{
+		Listener: ln,
+		trusted:  trusted,
+		timeout:  timeout,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *proxyListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.handshake(conn)
+	}
+}
+
+func (l *proxyListener) handshake(conn net.Conn) {
+	if len(l.trusted) > 0 && !l.trusted.containsAddr(conn.RemoteAddr().String()) {
+		l.deliver(conn)
+		return
+	}
+	pc, err := readProxyHeader(conn, l.timeout)
+	if err != nil {
+		log.Printf("PROXY protocol: dropping connection from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	l.deliver(pc)
+}
+
+func (l *proxyListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *proxyListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// proxyConn is a connection whose PROXY header was read. RemoteAddr is the
+// source address from the header; peer is the proxy's socket address.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// peer is the address of the proxy that opened the connection.
+func (c *proxyConn) peer() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads the PROXY header conn must start with. A v1
+// "UNKNOWN" or v2 LOCAL header keeps the socket address.
+func readProxyHeader(conn net.Conn, timeout time.Duration) (*proxyConn, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	br := bufio.NewReader(conn)
+	pc := &proxyConn{Conn: conn, r: br, remote: conn.RemoteAddr()}
+	start, err := br.Peek(5)
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if string(start) == "PROXY" {
+		return pc, pc.readV1()
+	}
+	sig, err := br.Peek(len(proxyV2Signature))
+	if err != nil || !bytes.Equal(sig, proxyV2Signature) {
+		return nil, errors.New("connection does not start with a PROXY protocol header")
+	}
+	return pc, pc.readV2()
+}
+
+// readV1 parses "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func (c *proxyConn) readV1() error {
+	var line []byte
+	for len(line) < 107 {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("reading v1 header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return errors.New("v1 header is not terminated by CRLF within 107 bytes")
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("malformed v1 header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return fmt.Errorf("malformed v1 source address in %q", line)
+	}
+	c.remote = &net.TCPAddr{IP: ip, Port: port}
+	return nil
+}
+
+// readV2 parses the binary header: signature, version and command, address
+// family, length, then the addresses and optional TLVs (ignored).
+func (c *proxyConn) readV2() error {
+	var hdr [16]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return fmt.Errorf("reading v2 header: %w", err)
+	}
+	if hdr[12]>>4 != 2 {
+		return fmt.Errorf("unsupported PROXY protocol version %d", hdr[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return fmt.Errorf("reading v2 addresses: %w", err)
+	}
+	switch hdr[12] & 0x0f {
+	case 0x0: // LOCAL: health checks from the proxy itself
+		return nil
+	case 0x1: // PROXY
+	default:
+		return fmt.Errorf("unsupported v2 command %d", hdr[12]&0x0f)
+	}
+	switch hdr[13] >> 4 {
+	case 0x1: // IPv4
+		if len(payload) < 12 {
+			return errors.New("v2 IPv4 address block too short")
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+	case 0x2: // IPv6
+		if len(payload) < 36 {
+			return errors.New("v2 IPv6 address block too short")
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+	}
+	// AF_UNSPEC and unix sockets keep the socket address
+	return nil
+}
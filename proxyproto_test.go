@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// proxyV2Header builds a v2 header: command (0 LOCAL, 1 PROXY), address
+// family byte and address block.
+func proxyV2Header(command, family byte, addrs []byte) []byte {
+	hdr := append([]byte{}, proxyV2Signature...)
+	hdr = append(hdr, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(hdr[14:16], uint16(len(addrs)))
+	return append(hdr, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	ipv6 := make([]byte, 36)
+	copy(ipv6, net.ParseIP("2001:db8::1"))
+	copy(ipv6[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(ipv6[32:], 4711)
+	binary.BigEndian.PutUint16(ipv6[34:], 443)
+	withTLV := append(append([]byte{}, ipv4...), 0x01, 0x00, 0x02, 'h', '2')
+
+	tests := []struct {
+		name   string
+		header string
+		remote string // "" keeps the socket address
+		err    bool
+	}{
+		{"v1 TCP4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "192.0.2.1:56324", false},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::1 2001:db8::2 4711 443\r\n", "[2001:db8::1]:4711", false},
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\n", "", false},
+		{"v1 without CRLF", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n", "", true},
+		{"v1 missing port", "PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n", "", true},
+		{"v1 bad address", "PROXY TCP4 192.0.2.x 198.51.100.1 56324 443\r\n", "", true},
+		{"v1 port out of range", "PROXY TCP4 192.0.2.1 198.51.100.1 70000 443\r\n", "", true},
+		{"v1 unknown protocol", "PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n", "", true},
+		{"v2 IPv4", string(proxyV2Header(1, 0x11, ipv4)), "192.0.2.1:56324", false},
+		{"v2 IPv6", string(proxyV2Header(1, 0x21, ipv6)), "[2001:db8::1]:4711", false},
+		{"v2 with TLVs", string(proxyV2Header(1, 0x11, withTLV)), "192.0.2.1:56324", false},
+		{"v2 LOCAL", string(proxyV2Header(0, 0x00, nil)), "", false},
+		{"v2 unspecified family", string(proxyV2Header(1, 0x00, nil)), "", false},
+		{"v2 short IPv4 block", string(proxyV2Header(1, 0x11, ipv4[:8])), "", true},
+		{"v2 unknown command", string(proxyV2Header(2, 0x11, ipv4)), "", true},
+		{"no header", "GET / HTTP/1.1\r\n\r\n", "", true},
+	}
+	for _, tt := range tests {
+		server, client := net.Pipe()
+		go func() {
+			client.Write([]byte(tt.header + "GET"))
+			client.Close()
+		}()
+		pc, err := readProxyHeader(server, time.Second)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: accepted, want an error", tt.name)
+			}
+			server.Close()
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			server.Close()
+			continue
+		}
+		want := tt.remote
+		if want == "" {
+			want = server.RemoteAddr().String()
+		}
+		if got := pc.RemoteAddr().String(); got != want {
+			t.Errorf("%s: remote %s, want %s", tt.name, got, want)
+		}
+		// The request after the header is read as it was sent
+		if rest, _ := io.ReadAll(pc); string(rest) != "GET" {
+			t.Errorf("%s: read %q after the header, want %q", tt.name, rest, "GET")
+		}
+		server.Close()
+	}
+}
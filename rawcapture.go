@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Raw capture (RAW_CAPTURE=true) records the exact bytes each HTTP/1.x request
+// arrived with: the request line, the headers with their original casing,
+// order and duplicates, and the body with its framing (chunk sizes included).
+// net/http canonicalizes and reorders headers, which hides exactly what picky
+// gateways reject.
+
+// captureListener hands out connections that keep the bytes read from them,
+// at most max bytes per request (0: no limit).
+type captureListener struct {
+	net.Listener
+	max int
+}
+
+func (l captureListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &captureConn{Conn: conn, framer: requestFramer{max: l.max}}, nil
+}
+
+// captureConn splits the bytes read from it into requests as they arrive.
+// Requests on one connection are handled one after another, so the oldest
+// request not yet taken is always the current one.
+type captureConn struct {
+	net.Conn
+	mu     sync.Mutex
+	framer requestFramer
+}
+
+func (c *captureConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.framer.feed(p[:n])
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// take removes the current request's bytes. A request whose body was not
+// read completely yet takes what arrived so far; the rest of it is dropped
+// when it arrives, so later requests stay aligned.
+func (c *captureConn) take() capturedRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.framer.take()
+}
+
+// capturedRequest is the start of one request's bytes and its full size.
+type capturedRequest struct {
+	data     []byte
+	size     int64
+	complete bool // all of the request arrived before it was taken
+}
+
+// maxFramedLine bounds a head, chunk size or trailer line. Longer lines are
+// rejected by net/http, which closes the connection.
+const maxFramedLine = http.DefaultMaxHeaderBytes
+
+type framerState int
+
+const (
+	framingHead framerState = iota
+	framingBody
+	framingChunkSize
+	framingChunkData
+	framingChunkEnd
+	framingTrailer
+	framingLost // the bytes no longer look like requests (upgraded or malformed)
+)
+
+// requestFramer follows HTTP/1.x request framing over a stream of bytes: the
+// head up to the blank line, then a Content-Length body or a chunked body
+// with its trailers. It keeps at most max bytes of each request and only the
+// current line for parsing, so large bodies are counted, not buffered.
+type requestFramer struct {
+	max  int
+	done []capturedRequest // received requests not yet taken
+
+	cur           capturedRequest
+	state         framerState
+	line          []byte // the line being received
+	requestLine   bool   // the request line of cur was seen
+	contentLength int64
+	chunked       bool
+	remaining     int64 // body or chunk bytes still to come
+	discard       bool  // cur was taken before it was complete
+}
+
+func (f *requestFramer) feed(p []byte) {
+	for len(p) > 0 {
+		var n int
+		switch f.state {
+		case framingBody, framingChunkData:
+			n = len(p)
+			if int64(n) > f.remaining {
+				n = int(f.remaining)
+			}
+			f.remaining -= int64(n)
+			f.keep(p[:n])
+			if f.remaining == 0 {
+				if f.state == framingBody {
+					f.finish()
+				} else {
+					f.state = framingChunkEnd
+				}
+			}
+		case framingLost:
+			n = len(p)
+			f.keep(p)
+		default:
+			n = bytes.IndexByte(p, '\n') + 1
+			if n == 0 {
+				n = len(p)
+			}
+			f.keep(p[:n])
+			if len(f.line)+n > maxFramedLine {
+				f.state, f.line = framingLost, nil
+				continue
+			}
+			f.line = append(f.line, p[:n]...)
+			if p[n-1] == '\n' {
+				line := bytes.TrimRight(f.line, "\r\n")
+				f.line = f.line[:0]
+				f.endLine(line)
+			}
+		}
+		p = p[n:]
+	}
+}
+
+// keep adds received bytes to the current request.
+func (f *requestFramer) keep(b []byte) {
+	if f.discard {
+		return
+	}
+	f.cur.size += int64(len(b))
+	room := len(b)
+	if f.max > 0 && f.max-len(f.cur.data) < room {
+		room = f.max - len(f.cur.data)
+	}
+	if room > 0 {
+		f.cur.data = append(f.cur.data, b[:room]...)
+	}
+}
+
+// endLine moves on after a complete line of the head, a chunk size line, the
+// end of a chunk or a trailer.
+func (f *requestFramer) endLine(line []byte) {
+	switch f.state {
+	case framingHead:
+		switch {
+		case len(line) == 0 && !f.requestLine:
+			// Blank lines before the request line are ignored
+		case len(line) == 0 && f.chunked:
+			f.state = framingChunkSize
+		case len(line) == 0 && f.contentLength > 0:
+			f.state, f.remaining = framingBody, f.contentLength
+		case len(line) == 0:
+			f.finish()
+		case !f.requestLine:
+			f.requestLine = true
+		default:
+			f.headerLine(line)
+		}
+	case framingChunkSize:
+		if i := bytes.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		size, err := strconv.ParseInt(string(bytes.TrimSpace(line)), 16, 64)
+		switch {
+		case err != nil || size < 0:
+			f.state = framingLost
+		case size == 0:
+			f.state = framingTrailer
+		default:
+			f.state, f.remaining = framingChunkData, size
+		}
+	case framingChunkEnd:
+		f.state = framingChunkSize
+	case framingTrailer:
+		if len(line) == 0 {
+			f.finish()
+		}
+	}
+}
+
+// headerLine notes the framing headers. Transfer-Encoding wins over
+// Content-Length, as in net/http.
+func (f *requestFramer) headerLine(line []byte) {
+	name, value, ok := bytes.Cut(line, []byte(":"))
+	if !ok {
+		return
+	}
+	name, value = bytes.TrimSpace(name), bytes.TrimSpace(value)
+	switch {
+	case bytes.EqualFold(name, []byte("Transfer-Encoding")):
+		codings := bytes.Split(value, []byte(","))
+		f.chunked = bytes.EqualFold(bytes.TrimSpace(codings[len(codings)-1]), []byte("chunked"))
+	case bytes.EqualFold(name, []byte("Content-Length")):
+		if n, err := strconv.ParseInt(string(value), 10, 64); err == nil && n >= 0 {
+			f.contentLength = n
+		}
+	}
+}
+
+// finish queues the current request and starts the next one.
+func (f *requestFramer) finish() {
+	if !f.discard {
+		f.cur.complete = true
+		f.done = append(f.done, f.cur)
+	}
+	f.cur, f.discard = capturedRequest{}, false
+	f.state, f.requestLine, f.contentLength, f.chunked = framingHead, false, 0, false
+}
+
+// take returns the oldest received request, or what arrived of the current
+// one. The rest of a request taken incomplete is not kept.
+func (f *requestFramer) take() capturedRequest {
+	if len(f.done) > 0 {
+		req := f.done[0]
+		f.done = f.done[1:]
+		return req
+	}
+	req := f.cur
+	f.cur, f.discard = capturedRequest{}, true
+	return req
+}
+
+type captureConnKey struct{}
+
+// captureConnContext makes the connection reachable from its requests.
+func captureConnContext(ctx context.Context, c net.Conn) context.Context {
+	if cc, ok := c.(*captureConn); ok {
+		return context.WithValue(ctx, captureConnKey{}, cc)
+	}
+	return ctx
+}
+
+// rawRequest is a request's captured bytes, cut to the configured maximum.
+type rawRequest struct {
+	conn      *captureConn
+	taken     bool
+	data      []byte
+	size      int64
+	truncated bool // data is not the whole request
+}
+
+type rawRequestKey struct{}
+
+// rawCaptureMiddleware attributes the bytes of every request on a capturing
+// connection, including requests that never reach the logging middleware
+// (such as CORS preflights), so later requests stay aligned.
+func rawCaptureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, ok := r.Context().Value(captureConnKey{}).(*captureConn)
+		if !ok || r.ProtoMajor != 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		raw := &rawRequest{conn: conn}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rawRequestKey{}, raw)))
+		raw.take()
+	})
+}
+
+// rawRequestFrom returns r's captured bytes once its body has been read, or
+// nil when raw capture is off.
+func rawRequestFrom(r *http.Request) *rawRequest {
+	raw, ok := r.Context().Value(rawRequestKey{}).(*rawRequest)
+	if !ok {
+		return nil
+	}
+	raw.take()
+	return raw
+}
+
+func (raw *rawRequest) take() {
+	if raw.taken {
+		return
+	}
+	raw.taken = true
+	req := raw.conn.take()
+	raw.data, raw.size = req.data, req.size
+	raw.truncated = !req.complete || int64(len(req.data)) < req.size
+}
+
+// head is the request line and header lines as received, without line endings.
+func (raw *rawRequest) head() []string {
+	head := raw.data
+	if i := bytes.Index(head, []byte("\r\n\r\n")); i >= 0 {
+		head = head[:i]
+	} else if i := bytes.Index(head, []byte("\n\n")); i >= 0 {
+		head = head[:i]
+	}
+	var lines []string
+	for _, line := range bytes.Split(head, []byte("\n")) {
+		lines = append(lines, string(bytes.TrimSuffix(line, []byte("\r"))))
+	}
+	return lines
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRequestFramer(t *testing.T) {
+	const (
+		get      = "GET /users HTTP/1.1\r\nHost: a\r\n\r\n"
+		post     = "POST /users HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\n{\"id\":\"a\"}"
+		chunked  = "POST /up HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\nA\r\n0123456789\r\n0\r\nX-Sum: 1\r\n\r\n"
+		bareLF   = "GET / HTTP/1.1\nHost: a\n\n"
+		teWinsCL = "POST / HTTP/1.1\r\nContent-Length: 100\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n"
+	)
+	tests := []struct {
+		name   string
+		stream string
+		want   []string // complete requests in order
+	}{
+		{"keep-alive", get + post + get, []string{get, post, get}},
+		{"chunked with extension and trailer", chunked + get, []string{chunked, get}},
+		{"bare LF", bareLF + get, []string{bareLF, get}},
+		{"leading blank line", "\r\n" + get, []string{"\r\n" + get}},
+		{"Transfer-Encoding wins over Content-Length", teWinsCL + get, []string{teWinsCL, get}},
+		{"partial", get + post[:20], []string{get}},
+	}
+	for _, tt := range tests {
+		// Fed in every split: whole, then byte by byte
+		for _, step := range []int{len(tt.stream), 1} {
+			f := requestFramer{}
+			for i := 0; i < len(tt.stream); i += step {
+				f.feed([]byte(tt.stream[i:min(i+step, len(tt.stream))]))
+			}
+			if len(f.done) != len(tt.want) {
+				t.Errorf("%s (step %d): %d requests, want %d", tt.name, step, len(f.done), len(tt.want))
+				continue
+			}
+			for i, req := range f.done {
+				if string(req.data) != tt.want[i] || req.size != int64(len(tt.want[i])) || !req.complete {
+					t.Errorf("%s (step %d): request %d is %q (%d bytes), want %q", tt.name, step, i, req.data, req.size, tt.want[i])
+				}
+			}
+		}
+	}
+}
+
+func TestRequestFramerCapsAndTakesIncomplete(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	post := "POST / HTTP/1.1\r\nContent-Length: 1000\r\n\r\n" + body
+	get := "GET / HTTP/1.1\r\n\r\n"
+
+	f := requestFramer{max: 64}
+	f.feed([]byte(post + get))
+	req := f.take()
+	if len(req.data) != 64 || req.size != int64(len(post)) || !req.complete {
+		t.Errorf("capped request: %d bytes kept of %d, complete %t; want 64 of %d", len(req.data), req.size, req.complete, len(post))
+	}
+	if req := f.take(); string(req.data) != get {
+		t.Errorf("next request is %q, want %q", req.data, get)
+	}
+
+	// Taken before its body arrived: the rest of it is not attributed to the next request
+	f = requestFramer{}
+	f.feed([]byte(post[:100]))
+	if req := f.take(); string(req.data) != post[:100] || req.complete {
+		t.Errorf("incomplete request is %q (complete %t), want %q", req.data, req.complete, post[:100])
+	}
+	f.feed([]byte(post[100:] + get))
+	if req := f.take(); string(req.data) != get {
+		t.Errorf("request after an incomplete one is %q, want %q", req.data, get)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// relation declares that Field of records in Collection references records in Target,
+// e.g. orders.user_id -> users or orders.products -> products.
+type relation struct {
+	Collection string `json:"collection"`
+	Field      string `json:"field"`
+	Target     string `json:"target"`
+	// As is the name used with ?expand= and the key the related records are
+	// inlined under. Defaults to Field without a trailing "_id".
+	As string `json:"as"`
+	// OnDelete controls what happens when a referenced record is deleted:
+	// "cascade" deletes the referencing records, "restrict" rejects the delete
+	// with 409 Conflict, and "" leaves the references dangling.
+	OnDelete string `json:"onDelete"`
+}
+
+// ref identifies one stored record.
+type ref struct {
+	collection string
+	id         string
+}
+
+func (r ref) String() string { return r.collection + "/" + r.id }
+
+func (rel relation) validate(s *store) error {
+	if s.collections[rel.Collection] == nil {
+		return fmt.Errorf("unknown collection %q", rel.Collection)
+	}
+	if s.collections[rel.Target] == nil {
+		return fmt.Errorf("unknown target collection %q", rel.Target)
+	}
+	if rel.Field == "" {
+		return fmt.Errorf("%s: field is required", rel.Collection)
+	}
+	switch rel.OnDelete {
+	case "", "cascade", "restrict":
+	default:
+		return fmt.Errorf("%s.%s: unsupported onDelete %q", rel.Collection, rel.Field, rel.OnDelete)
+	}
+	return nil
+}
+
+func (rel relation) withDefaults() relation {
+	if rel.As == "" {
+		rel.As = strings.TrimSuffix(rel.Field, "_id")
+	}
+	return rel
+}
+
+// refValues returns the ids held by a reference field. The field may be a
+// single id, a list of ids, or a list of objects carrying an "id".
+func refValues(rec record, field string) []string {
+	switch v := rec[field].(type) {
+	case string:
+		return []string{v}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case []interface{}:
+		ids := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item := item.(type) {
+			case string:
+				ids = append(ids, item)
+			case float64:
+				ids = append(ids, strconv.FormatFloat(item, 'f', -1, 64))
+			case map[string]interface{}:
+				if id := idOf(item); id != "" {
+					ids = append(ids, id)
+				}
+			}
+		}
+		return ids
+	}
+	return nil
+}
+
+func refersTo(rec record, field, id string) bool {
+	for _, v := range refValues(rec, field) {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// childRelation finds the relation through which records of child point at parent.
+func (s *store) childRelation(parent, child string) (relation, bool) {
+	for _, rel := range s.relations {
+		if rel.Target == parent && rel.Collection == child {
+			return rel, true
+		}
+	}
+	return relation{}, false
+}
+
+// related lists the records of child that reference parent/id, e.g. the orders of a user.
+func (s *store) related(parent, id, child string) ([]record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p := s.collections[parent]
+	if p == nil || p.byID[id] == nil {
+		return nil, errNotFound
+	}
+	rel, ok := s.childRelation(parent, child)
+	if !ok {
+		return nil, errNotFound
+	}
+	out := make([]record, 0)
+	for _, rec := range s.collections[child].all() {
+		if refersTo(rec, rel.Field, id) {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+// expand returns a copy of rec with the requested relations inlined. Names may be
+// a relation's "as" name (orders -> user, products) or the name of a child
+// collection (users -> orders). Unknown names are ignored.
+func (s *store) expand(name string, rec record, names []string) record {
+	if len(names) == 0 {
+		return rec
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := copyRecord(rec)
+	id := idOf(rec)
+	for _, want := range names {
+		for _, rel := range s.relations {
+			switch {
+			case rel.Collection == name && rel.As == want:
+				target := s.collections[rel.Target]
+				if _, isList := rec[rel.Field].([]interface{}); isList {
+					items := make([]record, 0)
+					for _, refID := range refValues(rec, rel.Field) {
+						if t := target.byID[refID]; t != nil {
+							items = append(items, t)
+						}
+					}
+					out[rel.As] = items
+				} else if ids := refValues(rec, rel.Field); len(ids) == 1 {
+					if t := target.byID[ids[0]]; t != nil {
+						out[rel.As] = t
+					} else {
+						out[rel.As] = nil
+					}
+				}
+			case rel.Target == name && rel.Collection == want:
+				items := make([]record, 0)
+				for _, child := range s.collections[rel.Collection].all() {
+					if refersTo(child, rel.Field, id) {
+						items = append(items, child)
+					}
+				}
+				out[want] = items
+			}
+		}
+	}
+	return out
+}
+
+// planDeleteLocked collects every record removed by deleting target, following
+// cascading relations, and fails if a restricting relation still references one
+// of them. The caller must hold the write lock.
+func (s *store) planDeleteLocked(target ref) ([]ref, error) {
+	doomed := []ref{target}
+	seen := map[ref]bool{target: true}
+
+	for i := 0; i < len(doomed); i++ {
+		cur := doomed[i]
+		for _, rel := range s.relations {
+			if rel.Target != cur.collection || rel.OnDelete != "cascade" {
+				continue
+			}
+			for _, child := range s.collections[rel.Collection].all() {
+				r := ref{collection: rel.Collection, id: idOf(child)}
+				if !seen[r] && refersTo(child, rel.Field, cur.id) {
+					seen[r] = true
+					doomed = append(doomed, r)
+				}
+			}
+		}
+	}
+
+	for _, cur := range doomed {
+		for _, rel := range s.relations {
+			if rel.Target != cur.collection || rel.OnDelete != "restrict" {
+				continue
+			}
+			for _, child := range s.collections[rel.Collection].all() {
+				r := ref{collection: rel.Collection, id: idOf(child)}
+				if !seen[r] && refersTo(child, rel.Field, cur.id) {
+					return nil, &conflictError{msg: fmt.Sprintf("%s is still referenced by %s (%s.%s)", cur, r, rel.Collection, rel.Field)}
+				}
+			}
+		}
+	}
+	return doomed, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// responseCache keeps response files in memory. Entries are revalidated
+// against the file's modification time and size at most every checkEvery, so
+// edits are still picked up without a restart; least recently used entries
+// are evicted once the cache holds more than maxBytes.
+type responseCache struct {
+	enabled    bool
+	checkEvery time.Duration
+	maxBytes   int64
+
+	mu        sync.Mutex
+	entries   map[string]*cacheEntry
+	size      int64
+	hits      int64
+	misses    int64
+	reloads   int64
+	evictions int64
+}
+
+type cacheEntry struct {
+	data     []byte
+	modTime  time.Time
+	checked  time.Time
+	lastUsed time.Time
+}
+
+func newResponseCache(enabled bool, checkEvery time.Duration, maxBytes int64) *responseCache {
+	return &responseCache{
+		enabled:    enabled,
+		checkEvery: checkEvery,
+		maxBytes:   maxBytes,
+		entries:    make(map[string]*cacheEntry),
+	}
+}
+
+// get returns the contents of the file at path.
+func (c *responseCache) get(path string) ([]byte, error) {
+	if !c.enabled {
+		return os.ReadFile(path)
+	}
+	now := time.Now()
+	c.mu.Lock()
+	if e := c.entries[path]; e != nil && now.Sub(e.checked) < c.checkEvery {
+		e.lastUsed = now
+		c.hits++
+		c.mu.Unlock()
+		return e.data, nil
+	}
+	c.mu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		c.forget(path)
+		return nil, err
+	}
+	c.mu.Lock()
+	e := c.entries[path]
+	if e != nil && e.modTime.Equal(info.ModTime()) && int64(len(e.data)) == info.Size() {
+		e.checked, e.lastUsed = now, now
+		c.hits++
+		c.mu.Unlock()
+		return e.data, nil
+	}
+	c.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.forget(path)
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old := c.entries[path]; old != nil {
+		c.reloads++
+		c.size -= int64(len(old.data))
+		delete(c.entries, path)
+	} else {
+		c.misses++
+	}
+	if int64(len(data)) <= c.maxBytes {
+		c.entries[path] = &cacheEntry{data: data, modTime: info.ModTime(), checked: now, lastUsed: now}
+		c.size += int64(len(data))
+		c.evictLocked()
+	}
+	return data, nil
+}
+
+func (c *responseCache) forget(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.entries[path]; e != nil {
+		c.size -= int64(len(e.data))
+		delete(c.entries, path)
+	}
+}
+
+// evictLocked drops least recently used entries until the cache fits maxBytes.
+func (c *responseCache) evictLocked() {
+	for c.size > c.maxBytes {
+		var oldest string
+		for path, e := range c.entries {
+			if oldest == "" || e.lastUsed.Before(c.entries[oldest].lastUsed) {
+				oldest = path
+			}
+		}
+		c.size -= int64(len(c.entries[oldest].data))
+		delete(c.entries, oldest)
+		c.evictions++
+	}
+}
+
+// preload reads every regular file below dir into the cache.
+func (c *responseCache) preload(dir string) (int, error) {
+	if !c.enabled {
+		return 0, nil
+	}
+	n := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if _, err := c.get(path); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+func (c *responseCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*cacheEntry)
+	c.size = 0
+}
+
+// stats reports the cache contents and hit/miss counters.
+func (c *responseCache) stats() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ratio := 0.0
+	if total := c.hits + c.misses + c.reloads; total > 0 {
+		ratio = float64(c.hits) / float64(total)
+	}
+	return map[string]interface{}{
+		"enabled":        c.enabled,
+		"entries":        len(c.entries),
+		"bytes":          c.size,
+		"max_bytes":      c.maxBytes,
+		"check_interval": c.checkEvery.String(),
+		"hits":           c.hits,
+		"misses":         c.misses,
+		"reloads":        c.reloads,
+		"evictions":      c.evictions,
+		"hit_ratio":      ratio,
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// validationError is returned when a request breaks a business rule about its
+// own content (missing fields, unknown references); it maps to 422.
+type validationError struct {
+	msg string
+}
+
+func (e *validationError) Error() string { return e.msg }
+
+// badRequestError is returned when a value cannot be used at all, e.g. a
+// negative quantity; it maps to 400.
+type badRequestError struct {
+	msg string
+}
+
+func (e *badRequestError) Error() string { return e.msg }
+
+// collectionRules is the optional business logic for one collection, read from RULES_FILE.
+//
+//	{
+//	  "orders": {
+//	    "required": ["user_id", "products"],
+//	    "requireRefs": true,
+//	    "items": {"field": "products", "quantity": "quantity", "price": "price", "stock": "stock", "total": "total"},
+//	    "status": {"field": "status", "initial": "pending", "transitions": {"pending": ["paid", "cancelled"]}, "release": ["cancelled"]}
+//	  }
+//	}
+type collectionRules struct {
+	// Required lists fields that must be present on create.
+	Required []string `json:"required"`
+	// RequireRefs rejects records whose relation fields point at missing records.
+	RequireRefs bool        `json:"requireRefs"`
+	Items       *itemsRule  `json:"items"`
+	Status      *statusRule `json:"status"`
+}
+
+// itemsRule treats a relation field as line items. Each item is either an id
+// (quantity 1) or an object {"id": ..., "<quantity>": n}.
+type itemsRule struct {
+	Field string `json:"field"`
+	// Quantity is the item key holding the ordered amount (default "quantity").
+	Quantity string `json:"quantity"`
+	// Price is the field on the referenced record used for the total (default "price").
+	Price string `json:"price"`
+	// Stock is the field on the referenced record holding available units. When set,
+	// items are reserved on create and out-of-stock requests fail with 409.
+	// Referenced records without the field are treated as unlimited.
+	Stock string `json:"stock"`
+	// Total is the field the computed sum of price*quantity is written to (default "total").
+	Total string `json:"total"`
+}
+
+// statusRule assigns an initial status and restricts later changes to the listed transitions.
+type statusRule struct {
+	Field       string              `json:"field"`
+	Initial     string              `json:"initial"`
+	Transitions map[string][]string `json:"transitions"`
+	// Release lists statuses that give reserved stock back (e.g. "cancelled").
+	Release []string `json:"release"`
+}
+
+// loadRules reads the rules file and checks it against the store's collections and relations.
+func loadRules(path string, s *store) (map[string]*collectionRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules map[string]*collectionRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for name, cr := range rules {
+		if s.collections[name] == nil {
+			return nil, fmt.Errorf("rules for unknown collection %q", name)
+		}
+		if it := cr.Items; it != nil {
+			if _, ok := s.relationFor(name, it.Field); !ok {
+				return nil, fmt.Errorf("%s: items field %q is not a declared relation", name, it.Field)
+			}
+			if it.Quantity == "" {
+				it.Quantity = "quantity"
+			}
+			if it.Price == "" {
+				it.Price = "price"
+			}
+			if it.Total == "" {
+				it.Total = "total"
+			}
+		}
+		if st := cr.Status; st != nil && st.Field == "" {
+			st.Field = "status"
+		}
+	}
+	return rules, nil
+}
+
+// relationFor finds the relation declared on collection.field.
+func (s *store) relationFor(collection, field string) (relation, bool) {
+	for _, rel := range s.relations {
+		if rel.Collection == collection && rel.Field == field {
+			return rel, true
+		}
+	}
+	return relation{}, false
+}
+
+// applyRulesLocked runs the collection's rules for a create (old == nil) or an
+// update and returns the record to store plus any referenced records whose
+// stock changed. Nothing is written; the caller commits on success while
+// still holding the write lock.
+func (s *store) applyRulesLocked(name string, old, rec record) (record, []ref, []record, error) {
+	cr := s.rules[name]
+	if cr == nil {
+		return rec, nil, nil, nil
+	}
+
+	if old == nil {
+		for _, field := range cr.Required {
+			if v, ok := rec[field]; !ok || v == nil {
+				return nil, nil, nil, &validationError{msg: fmt.Sprintf("%s: field %q is required", name, field)}
+			}
+		}
+	}
+
+	if cr.RequireRefs {
+		for _, rel := range s.relations {
+			if rel.Collection != name {
+				continue
+			}
+			target := s.collections[rel.Target]
+			for _, id := range refValues(rec, rel.Field) {
+				if target.byID[id] == nil {
+					return nil, nil, nil, &validationError{msg: fmt.Sprintf("%s.%s references unknown %s %q", name, rel.Field, rel.Target, id)}
+				}
+			}
+		}
+	}
+
+	if st := cr.Status; st != nil {
+		next, _ := rec[st.Field].(string)
+		if old == nil {
+			if next == "" {
+				next = st.Initial
+			} else if st.Initial != "" && next != st.Initial {
+				return nil, nil, nil, &validationError{msg: fmt.Sprintf("%s must be created with %s %q", name, st.Field, st.Initial)}
+			}
+			rec[st.Field] = next
+		} else {
+			prev, _ := old[st.Field].(string)
+			if next == "" {
+				next = prev
+				rec[st.Field] = prev
+			}
+			if next != prev && !contains(st.Transitions[prev], next) {
+				return nil, nil, nil, &conflictError{msg: fmt.Sprintf("%s cannot change %s from %q to %q (allowed: %s)",
+					name, st.Field, prev, next, strings.Join(st.Transitions[prev], ", "))}
+			}
+		}
+	}
+
+	it := cr.Items
+	if it == nil {
+		return rec, nil, nil, nil
+	}
+	rel, _ := s.relationFor(name, it.Field)
+	target := s.collections[rel.Target]
+
+	newQty, err := itemQuantities(rec, it)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rec[it.Total] = itemsTotal(target, newQty, it)
+
+	if it.Stock == "" {
+		return rec, nil, nil, nil
+	}
+
+	// Reserve the difference between what this record held before and what it holds now.
+	var oldQty map[string]float64
+	if old != nil && !cr.Status.releases(old) {
+		oldQty, _ = itemQuantities(old, it)
+	}
+	if cr.Status.releases(rec) {
+		newQty = nil
+	}
+	ids := make([]string, 0, len(newQty)+len(oldQty))
+	for id := range newQty {
+		ids = append(ids, id)
+	}
+	for id := range oldQty {
+		if _, dup := newQty[id]; !dup {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var refs []ref
+	var updated []record
+	for _, id := range ids {
+		delta := newQty[id] - oldQty[id]
+		t := target.byID[id]
+		if delta == 0 || t == nil {
+			continue
+		}
+		stock, ok := t[it.Stock].(float64)
+		if !ok {
+			continue
+		}
+		if stock < delta {
+			return nil, nil, nil, &conflictError{msg: fmt.Sprintf("%s %s is out of stock (requested %v, available %v)", rel.Target, id, delta, stock)}
+		}
+		changed := copyRecord(t)
+		changed[it.Stock] = stock - delta
+		refs = append(refs, ref{collection: rel.Target, id: id})
+		updated = append(updated, changed)
+	}
+	return rec, refs, updated, nil
+}
+
+// releaseStockLocked gives back the stock still reserved by records about to
+// be deleted, e.g. a pending order or the orders cascading from a user. It
+// returns the referenced records to commit, like applyRulesLocked.
+func (s *store) releaseStockLocked(doomed []ref) ([]ref, []record) {
+	gone := make(map[ref]bool, len(doomed))
+	for _, d := range doomed {
+		gone[d] = true
+	}
+	var refs []ref
+	var updated []record
+	index := make(map[ref]int)
+	for _, d := range doomed {
+		cr := s.rules[d.collection]
+		if cr == nil || cr.Items == nil || cr.Items.Stock == "" {
+			continue
+		}
+		rec := s.collections[d.collection].byID[d.id]
+		if rec == nil || cr.Status.releases(rec) {
+			continue
+		}
+		it := cr.Items
+		rel, _ := s.relationFor(d.collection, it.Field)
+		target := s.collections[rel.Target]
+		qty, _ := itemQuantities(rec, it)
+		ids := make([]string, 0, len(qty))
+		for id := range qty {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			key := ref{collection: rel.Target, id: id}
+			if gone[key] {
+				continue
+			}
+			i, seen := index[key]
+			if !seen {
+				t := target.byID[id]
+				if _, ok := t[it.Stock].(float64); t == nil || !ok {
+					continue
+				}
+				i = len(refs)
+				index[key] = i
+				refs = append(refs, key)
+				updated = append(updated, copyRecord(t))
+			}
+			stock, _ := updated[i][it.Stock].(float64)
+			updated[i][it.Stock] = stock + qty[id]
+		}
+	}
+	return refs, updated
+}
+
+// itemsTotal sums price*quantity over the referenced records, rounded to cents.
+func itemsTotal(target *collection, qty map[string]float64, it *itemsRule) float64 {
+	ids := make([]string, 0, len(qty))
+	for id := range qty {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	total := 0.0
+	for _, id := range ids {
+		if t := target.byID[id]; t != nil {
+			price, _ := t[it.Price].(float64)
+			total += price * qty[id]
+		}
+	}
+	return math.Round(total*100) / 100
+}
+
+// itemQuantities sums the quantity ordered per referenced id. Quantities
+// that are not positive whole numbers are skipped and the first is reported.
+func itemQuantities(rec record, it *itemsRule) (map[string]float64, error) {
+	qty := make(map[string]float64)
+	var err error
+	items, _ := rec[it.Field].([]interface{})
+	for _, item := range items {
+		switch item := item.(type) {
+		case string:
+			qty[item]++
+		case map[string]interface{}:
+			id := idOf(item)
+			if id == "" {
+				continue
+			}
+			v, ok := item[it.Quantity]
+			if !ok {
+				qty[id]++
+				continue
+			}
+			if n, isNumber := v.(float64); isNumber && n >= 1 && n == math.Trunc(n) {
+				qty[id] += n
+			} else if err == nil {
+				err = &badRequestError{msg: fmt.Sprintf("%s: %s of %s must be a positive whole number, got %v", it.Field, it.Quantity, id, v)}
+			}
+		}
+	}
+	return qty, err
+}
+
+func (st *statusRule) releases(rec record) bool {
+	if st == nil {
+		return false
+	}
+	status, _ := rec[st.Field].(string)
+	return contains(st.Release, status)
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
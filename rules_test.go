@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// ruleStep is one store operation; err is "", "bad request", "validation" or "conflict".
+type ruleStep struct {
+	op, collection, id, body string
+	err                      string
+}
+
+func TestStoreRules(t *testing.T) {
+	const twoMugs = `{"id": "o-1", "user_id": "user-002", "products": [{"id": "prod-003", "quantity": 2}]}`
+	tests := []struct {
+		name  string
+		steps []ruleStep
+		total float64            // of the last record written, when set
+		stock map[string]float64 // product stock after the steps
+	}{
+		{"required field", []ruleStep{{"create", "orders", "", `{"products": ["prod-001"]}`, "validation"}}, 0, nil},
+		{"unknown product", []ruleStep{{"create", "orders", "", `{"user_id": "user-001", "products": ["prod-999"]}`, "validation"}}, 0, nil},
+		{"created with another status", []ruleStep{{"create", "orders", "", `{"user_id": "user-001", "products": ["prod-001"], "status": "paid"}`, "validation"}}, 0, nil},
+		{"create reserves stock and sums the total", []ruleStep{
+			{"create", "orders", "", `{"user_id": "user-001", "products": [{"id": "prod-003", "quantity": 2}, "prod-002"]}`, ""},
+		}, 55.97, map[string]float64{"prod-003": 0, "prod-002": 24}},
+		{"out of stock", []ruleStep{
+			{"create", "orders", "", `{"user_id": "user-001", "products": [{"id": "prod-003", "quantity": 3}]}`, "conflict"},
+		}, 0, map[string]float64{"prod-003": 2}},
+		{"update reserves the difference", []ruleStep{
+			{"create", "orders", "", `{"id": "o-1", "user_id": "user-001", "products": ["prod-003"]}`, ""},
+			{"merge", "orders", "o-1", `{"products": [{"id": "prod-003", "quantity": 2}]}`, ""},
+			{"merge", "orders", "o-1", `{"products": [{"id": "prod-003", "quantity": 3}]}`, "conflict"},
+		}, 0, map[string]float64{"prod-003": 0}},
+		{"negative quantity", []ruleStep{
+			{"create", "orders", "", `{"user_id": "user-001", "products": [{"id": "prod-003", "quantity": -5}]}`, "bad request"},
+		}, 0, map[string]float64{"prod-003": 2}},
+		{"zero quantity", []ruleStep{
+			{"create", "orders", "", `{"user_id": "user-001", "products": [{"id": "prod-003", "quantity": 0}]}`, "bad request"},
+		}, 0, map[string]float64{"prod-003": 2}},
+		{"fractional quantity", []ruleStep{
+			{"create", "orders", "", `{"user_id": "user-001", "products": [{"id": "prod-003", "quantity": 1.5}]}`, "bad request"},
+		}, 0, map[string]float64{"prod-003": 2}},
+		{"quantity that is not a number", []ruleStep{
+			{"create", "orders", "", `{"user_id": "user-001", "products": [{"id": "prod-003", "quantity": "2"}]}`, "bad request"},
+		}, 0, map[string]float64{"prod-003": 2}},
+		{"negative quantity on update", []ruleStep{
+			{"create", "orders", "", twoMugs, ""},
+			{"merge", "orders", "o-1", `{"products": [{"id": "prod-003", "quantity": -1}]}`, "bad request"},
+		}, 0, map[string]float64{"prod-003": 0}},
+		{"disallowed transition", []ruleStep{
+			{"create", "orders", "", twoMugs, ""},
+			{"merge", "orders", "o-1", `{"status": "delivered"}`, "conflict"},
+		}, 0, map[string]float64{"prod-003": 0}},
+		{"cancelling releases stock", []ruleStep{
+			{"create", "orders", "", twoMugs, ""},
+			{"merge", "orders", "o-1", `{"status": "cancelled"}`, ""},
+		}, 0, map[string]float64{"prod-003": 2}},
+		{"deleting releases stock", []ruleStep{
+			{"create", "orders", "", twoMugs, ""},
+			{"delete", "orders", "o-1", "", ""},
+		}, 0, map[string]float64{"prod-003": 2}},
+		{"deleting a cancelled order releases nothing more", []ruleStep{
+			{"create", "orders", "", twoMugs, ""},
+			{"merge", "orders", "o-1", `{"status": "cancelled"}`, ""},
+			{"delete", "orders", "o-1", "", ""},
+		}, 0, map[string]float64{"prod-003": 2}},
+		{"cascading deletes release stock", []ruleStep{
+			{"create", "orders", "", twoMugs, ""},
+			{"create", "orders", "", `{"user_id": "user-002", "products": ["prod-003", "prod-001"]}`, "conflict"},
+			{"create", "orders", "", `{"user_id": "user-002", "products": ["prod-001", "prod-001"]}`, ""},
+			{"delete", "users", "user-002", "", ""},
+		}, 0, map[string]float64{"prod-003": 2, "prod-001": 5}},
+	}
+	for _, tt := range tests {
+		s, err := loadStore("store.json", "responses")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.rules, err = loadRules("rules.json", s); err != nil {
+			t.Fatal(err)
+		}
+		var last record
+		for i, step := range tt.steps {
+			var body record
+			if step.body != "" {
+				if err := json.Unmarshal([]byte(step.body), &body); err != nil {
+					t.Fatalf("%s: step %d: %v", tt.name, i+1, err)
+				}
+			}
+			var err error
+			switch step.op {
+			case "create":
+				last, err = s.create(step.collection, body)
+			case "merge":
+				last, err = s.update(step.collection, step.id, body, true)
+			case "delete":
+				_, err = s.delete(step.collection, step.id)
+			}
+			var validation *validationError
+			var conflict *conflictError
+			var bad *badRequestError
+			got := ""
+			if errors.As(err, &bad) {
+				got = "bad request"
+			} else if errors.As(err, &validation) {
+				got = "validation"
+			} else if errors.As(err, &conflict) {
+				got = "conflict"
+			} else if err != nil {
+				got = err.Error()
+			}
+			if got != step.err {
+				t.Errorf("%s: step %d (%s %s): got error %q, want %q", tt.name, i+1, step.op, step.collection, got, step.err)
+			}
+		}
+		if tt.total != 0 && last["total"] != tt.total {
+			t.Errorf("%s: total %v, want %v", tt.name, last["total"], tt.total)
+		}
+		for id, want := range tt.stock {
+			product, err := s.get("products", id)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if product["stock"] != want {
+				t.Errorf("%s: %s stock %v, want %v", tt.name, id, product["stock"], want)
+			}
+		}
+	}
+}
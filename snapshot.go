@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// snapshotVersion is bumped whenever the snapshot layout changes incompatibly.
+const snapshotVersion = 1
+
+// snapshot is the on-disk form of the mock's mutable state.
+type snapshot struct {
+	Version   int                        `json:"version"`
+	CreatedAt string                     `json:"created_at"`
+	State     map[string]json.RawMessage `json:"state"`
+}
+
+// statePart is a piece of server state that can be captured and restored.
+// prepareState decodes and validates a snapshot's data without changing
+// anything and returns the function that swaps it in, which cannot fail.
+type statePart interface {
+	snapshotState() (interface{}, error)
+	prepareState(data json.RawMessage) (apply func(), err error)
+}
+
+// stateRegistry collects every statePart so a snapshot covers the whole server.
+type stateRegistry struct {
+	mu    sync.Mutex
+	parts map[string]statePart
+}
+
+func newStateRegistry() *stateRegistry {
+	return &stateRegistry{parts: make(map[string]statePart)}
+}
+
+func (sr *stateRegistry) register(name string, part statePart) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.parts[name] = part
+}
+
+func (sr *stateRegistry) names() []string {
+	names := make([]string, 0, len(sr.parts))
+	for name := range sr.parts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (sr *stateRegistry) snapshot() (*snapshot, error) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	snap := &snapshot{
+		Version:   snapshotVersion,
+		CreatedAt: clock.Now().UTC().Format(time.RFC3339),
+		State:     make(map[string]json.RawMessage, len(sr.parts)),
+	}
+	for _, name := range sr.names() {
+		v, err := sr.parts[name].snapshotState()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		snap.State[name] = data
+	}
+	return snap, nil
+}
+
+// restore applies every part present in the snapshot. Parts the snapshot does
+// not mention keep their current state; unknown parts are reported. Every
+// part is decoded and validated before any is applied, so a bad snapshot
+// changes nothing.
+func (sr *stateRegistry) restore(snap *snapshot) error {
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d (want %d)", snap.Version, snapshotVersion)
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	for name := range snap.State {
+		if sr.parts[name] == nil {
+			return fmt.Errorf("snapshot contains unknown state %q", name)
+		}
+	}
+	var applies []func()
+	for _, name := range sr.names() {
+		data, ok := snap.State[name]
+		if !ok {
+			continue
+		}
+		apply, err := sr.parts[name].prepareState(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		applies = append(applies, apply)
+	}
+	for _, apply := range applies {
+		apply()
+	}
+	return nil
+}
+
+func (sr *stateRegistry) saveFile(path string) error {
+	snap, err := sr.snapshot()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (sr *stateRegistry) loadFile(path string) error {
+	snap, err := readSnapshotFile(path)
+	if err != nil {
+		return err
+	}
+	return sr.restore(snap)
+}
+
+func readSnapshotFile(path string) (*snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &snap, nil
+}
+
+// storeState is the snapshot form of a store.
+type storeState struct {
+	Collections map[string]collectionState `json:"collections"`
+}
+
+type collectionState struct {
+	NextID  int      `json:"next_id"`
+	Records []record `json:"records"`
+}
+
+func (s *store) snapshotState() (interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state := storeState{Collections: make(map[string]collectionState, len(s.collections))}
+	for name, c := range s.collections {
+		state.Collections[name] = collectionState{NextID: c.nextID, Records: c.all()}
+	}
+	return state, nil
+}
+
+// prepareState decodes the collections in the snapshot. Applying it replaces
+// their records; collections missing from the snapshot are left untouched.
+func (s *store) prepareState(data json.RawMessage) (func(), error) {
+	collections, err := decodeStoreState(data)
+	if err != nil {
+		return nil, err
+	}
+	return func() { s.replaceCollections(collections) }, nil
+}
+
+func decodeStoreState(data json.RawMessage) (map[string]*collection, error) {
+	var state storeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	collections := make(map[string]*collection, len(state.Collections))
+	for name, cs := range state.Collections {
+		c := newCollection(name)
+		for _, rec := range cs.Records {
+			if idOf(rec) == "" {
+				return nil, fmt.Errorf("%s: record without id", name)
+			}
+			c.insert(rec)
+		}
+		if cs.NextID > c.nextID {
+			c.nextID = cs.NextID
+		}
+		collections[name] = c
+	}
+	return collections, nil
+}
+
+func (s *store) replaceCollections(collections map[string]*collection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, c := range collections {
+		s.collections[name] = c
+	}
+}
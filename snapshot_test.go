@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// newTestState is a stateful setup with one users collection holding user-001.
+func newTestState(t *testing.T) (*stateRegistry, *namespaceManager, *store) {
+	t.Helper()
+	s := newStore()
+	s.collections["users"] = newCollection("users")
+	if _, err := s.create("users", record{"id": "user-001"}); err != nil {
+		t.Fatal(err)
+	}
+	namespaces := newNamespaceManager("X-Mock-Session", 0, 10, s)
+	state := newStateRegistry()
+	state.register("store", s)
+	state.register("namespaces", namespaces)
+	state.register("mocks", mocksStatePart{namespaces: namespaces})
+	state.register("counters", countersStatePart{namespaces: namespaces})
+	return state, namespaces, s
+}
+
+func userIDs(t *testing.T, s *store) []string {
+	t.Helper()
+	records, err := s.list("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(records))
+	for _, rec := range records {
+		ids = append(ids, idOf(rec))
+	}
+	return ids
+}
+
+func TestStateRegistryRestoreChangesNothingOnError(t *testing.T) {
+	state, namespaces, s := newTestState(t)
+	tests := []struct {
+		name  string
+		state map[string]string
+	}{
+		{"bad namespace record", map[string]string{
+			"store":      `{"collections":{"users":{"records":[{"id":"user-009"}]}}}`,
+			"mocks":      `{"":[{"path":"/x"}]}`,
+			"namespaces": `{"shard-1":{"collections":{"users":{"records":[{"name":"no id"}]}}}}`,
+		}},
+		{"bad store", map[string]string{
+			"mocks":      `{"":[{"path":"/x"}]}`,
+			"namespaces": `{"shard-1":{"collections":{}}}`,
+			"store":      `{"collections":[]}`,
+		}},
+		{"unknown part", map[string]string{
+			"store":   `{"collections":{"users":{"records":[{"id":"user-009"}]}}}`,
+			"journal": `{}`,
+		}},
+	}
+	for _, tt := range tests {
+		snap := &snapshot{Version: snapshotVersion, State: make(map[string]json.RawMessage)}
+		for name, data := range tt.state {
+			snap.State[name] = json.RawMessage(data)
+		}
+		if err := state.restore(snap); err == nil {
+			t.Errorf("%s: restored, want an error", tt.name)
+		}
+		if ids := userIDs(t, s); len(ids) != 1 || ids[0] != "user-001" {
+			t.Errorf("%s: users %v, want [user-001]", tt.name, ids)
+		}
+		if n := namespaces.def.mocks.len(); n != 0 {
+			t.Errorf("%s: %d mocks restored, want none", tt.name, n)
+		}
+		if n := len(namespaces.all()); n != 1 {
+			t.Errorf("%s: %d namespaces, want only the default one", tt.name, n)
+		}
+	}
+}
+
+func TestResetReturnsToRestoredSnapshot(t *testing.T) {
+	state, namespaces, s := newTestState(t)
+	snap := &snapshot{Version: snapshotVersion, State: map[string]json.RawMessage{
+		"store":      json.RawMessage(`{"collections":{"users":{"records":[{"id":"user-007"}]}}}`),
+		"namespaces": json.RawMessage(`{"shard-1":{"collections":{"users":{"records":[{"id":"user-042"}]}}}}`),
+	}}
+	if err := state.restore(snap); err != nil {
+		t.Fatal(err)
+	}
+	namespaces.rebase()
+
+	shard := namespaces.get("shard-1")
+	for _, st := range []*store{s, shard.store} {
+		if _, err := st.create("users", record{"id": "user-100"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	namespaces.reset(namespaces.def)
+	namespaces.reset(shard)
+	if ids := userIDs(t, s); len(ids) != 1 || ids[0] != "user-007" {
+		t.Errorf("default namespace: users %v after reset, want [user-007]", ids)
+	}
+	if ids := userIDs(t, shard.store); len(ids) != 1 || ids[0] != "user-042" {
+		t.Errorf("shard-1: users %v after reset, want [user-042]", ids)
+	}
+	if ids := userIDs(t, namespaces.get("shard-2").store); len(ids) != 1 || ids[0] != "user-007" {
+		t.Errorf("new namespace: users %v, want [user-007]", ids)
+	}
+}
+
+func TestRestoreContinuesNumbering(t *testing.T) {
+	tests := []struct {
+		name      string
+		state     map[string]string
+		wantMock  string
+		wantEntry int64
+	}{
+		{"mocks only", map[string]string{
+			"mocks": `{"":[{"id":"mock-3","path":"/x"},{"id":"custom","path":"/y"}]}`,
+		}, "mock-4", 1},
+		{"counters", map[string]string{
+			"mocks":    `{"":[{"id":"mock-3","path":"/x"}]}`,
+			"counters": `{"":{"requests":41,"mocks":7}}`,
+		}, "mock-8", 42},
+		{"counters behind the restored mocks", map[string]string{
+			"mocks":    `{"":[{"id":"mock-9","path":"/x"}]}`,
+			"counters": `{"":{"mocks":2}}`,
+		}, "mock-10", 1},
+	}
+	for _, tt := range tests {
+		state, namespaces, _ := newTestState(t)
+		snap := &snapshot{Version: snapshotVersion, State: make(map[string]json.RawMessage)}
+		for name, data := range tt.state {
+			snap.State[name] = json.RawMessage(data)
+		}
+		if err := state.restore(snap); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		m, err := namespaces.def.mocks.add(mockDef{Path: "/z"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.ID != tt.wantMock {
+			t.Errorf("%s: next mock %s, want %s", tt.name, m.ID, tt.wantMock)
+		}
+		namespaces.def.journal.add(journalEntry{})
+		if got := namespaces.def.journal.list()[0].ID; got != tt.wantEntry {
+			t.Errorf("%s: next request %d, want %d", tt.name, got, tt.wantEntry)
+		}
+	}
+}
+
+func TestSnapshotSavesCounters(t *testing.T) {
+	state, namespaces, _ := newTestState(t)
+	namespaces.get("shard-1").journal.add(journalEntry{})
+	if _, err := namespaces.def.mocks.add(mockDef{Path: "/x"}); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := state.snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(snap.State["counters"]), `{"":{"requests":0,"mocks":1},"shard-1":{"requests":1,"mocks":0}}`; got != want {
+		t.Errorf("counters %s, want %s", got, want)
+	}
+}
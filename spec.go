@@ -0,0 +1,677 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// openAPISpec indexes the operations of the OpenAPI document so any of them
+// can answer with a documented response chosen by a Prism-style Prefer header:
+//
+//	Prefer: code=404
+//	Prefer: code=200, example=admin
+//	Prefer: dynamic=true
+type openAPISpec struct {
+	file       string
+	version    string     // "2.0", "3.0.x" or "3.1.x" as declared by the file
+	root       *yaml.Node // the document as written; nil when converted or bundled
+	doc        map[string]interface{}
+	basePath   string // path prefix of the API (Swagger basePath or the first server URL)
+	warnings   []string
+	operations []specOperation
+}
+
+// specOperation is one method of a path in the spec.
+type specOperation struct {
+	Method    string
+	Path      string // "{name}" segments match any value
+	ID        string
+	Responses map[string]interface{} // keyed by status code, "4XX" or "default"
+}
+
+var specMethods = map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true}
+
+// loadOpenAPISpec reads a Swagger 2.0, OpenAPI 3.0 or 3.1 document (YAML or
+// JSON). External $refs are bundled into the document and Swagger 2.0 is
+// converted to the OpenAPI 3.0 layout, so the rest of the server only deals
+// with one shape.
+func loadOpenAPISpec(file string) (*openAPISpec, error) {
+	raw, root, err := readSpecFile(file)
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s is not a mapping", file)
+	}
+
+	spec := &openAPISpec{file: file, root: root, doc: doc}
+	openapi, _ := doc["openapi"].(string)
+	switch {
+	case doc["swagger"] != nil:
+		spec.version = fmt.Sprint(doc["swagger"])
+		if spec.version != "2.0" && spec.version != "2" {
+			return nil, fmt.Errorf("%s: unsupported Swagger version %s", file, spec.version)
+		}
+		spec.version = "2.0"
+	case strings.HasPrefix(openapi, "3.0.") || strings.HasPrefix(openapi, "3.1."):
+		spec.version = openapi
+	case openapi != "":
+		return nil, fmt.Errorf("%s: unsupported OpenAPI version %s (3.0.x and 3.1.x are supported)", file, openapi)
+	default:
+		return nil, fmt.Errorf("%s has neither an openapi nor a swagger version field", file)
+	}
+
+	if bundled, err := bundleExternalRefs(doc, file); err != nil {
+		return nil, err
+	} else if bundled > 0 {
+		spec.root = nil
+	}
+	if spec.version == "2.0" {
+		if spec.doc, err = spec.convertSwagger2(); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		spec.root = nil
+	}
+	spec.basePath = specBasePath(spec.doc)
+	spec.warnings = unsupportedConstructs(spec.doc)
+
+	paths, _ := spec.doc["paths"].(map[string]interface{})
+	for path, node := range paths {
+		item, err := spec.resolveMap(node)
+		if err != nil {
+			return nil, fmt.Errorf("paths %s: %w", path, err)
+		}
+		for method, opNode := range item {
+			if !specMethods[method] {
+				continue
+			}
+			op, err := spec.resolveMap(opNode)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+			responses, err := spec.resolveMap(op["responses"])
+			if err != nil {
+				return nil, fmt.Errorf("%s %s responses: %w", strings.ToUpper(method), path, err)
+			}
+			for code, resp := range responses {
+				if _, err := spec.resolveMap(resp); err != nil {
+					return nil, fmt.Errorf("%s %s response %s: %w", strings.ToUpper(method), path, code, err)
+				}
+			}
+			id, _ := op["operationId"].(string)
+			spec.operations = append(spec.operations, specOperation{Method: strings.ToUpper(method), Path: path, ID: id, Responses: responses})
+		}
+	}
+	sort.Slice(spec.operations, func(i, j int) bool {
+		if c := comparePathSpecificity(spec.operations[i].Path, spec.operations[j].Path); c != 0 {
+			return c < 0
+		}
+		return spec.operations[i].Method < spec.operations[j].Method
+	})
+	return spec, nil
+}
+
+// describe names the spec version, noting conversion and the base path.
+func (s *openAPISpec) describe() string {
+	d := "OpenAPI " + s.version
+	if s.version == "2.0" {
+		d = "Swagger 2.0, converted to OpenAPI 3.0"
+	}
+	if s.basePath != "" {
+		d += ", base path " + s.basePath
+	}
+	return d
+}
+
+// readSpecFile decodes a YAML or JSON file into JSON-compatible values.
+func readSpecFile(file string) (interface{}, *yaml.Node, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", file, err)
+	}
+	var raw interface{}
+	if err := root.Decode(&raw); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return jsonCompatible(raw), &root, nil
+}
+
+// specBasePath is the path of the first server URL, e.g. "/v1" for
+// "https://api.example.com/v1"; operations are served below it.
+func specBasePath(doc map[string]interface{}) string {
+	servers, _ := doc["servers"].([]interface{})
+	if len(servers) == 0 {
+		return ""
+	}
+	server, _ := servers[0].(map[string]interface{})
+	raw, _ := server["url"].(string)
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+// resolve follows $ref until it reaches a node that is not a reference.
+// External references were bundled at load time, so every $ref is a pointer
+// into the document. In OpenAPI 3.1 keywords next to a $ref apply too.
+func (s *openAPISpec) resolve(node interface{}) (interface{}, error) {
+	for hops := 0; hops < 32; hops++ {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return node, nil
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return node, nil
+		}
+		if !strings.HasPrefix(ref, "#") {
+			return nil, fmt.Errorf("unresolved $ref %q", ref)
+		}
+		target, err := s.pointer(ref)
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := target.(map[string]interface{}); ok && len(m) > 1 && strings.HasPrefix(s.version, "3.1.") {
+			merged := make(map[string]interface{}, len(t)+len(m))
+			for k, v := range t {
+				merged[k] = v
+			}
+			for k, v := range m {
+				if k != "$ref" {
+					merged[k] = v
+				}
+			}
+			target = merged
+		}
+		node = target
+	}
+	return nil, fmt.Errorf("too many nested $ref")
+}
+
+// pointer returns the node a local reference ("#/components/schemas/User") points to.
+func (s *openAPISpec) pointer(ref string) (interface{}, error) {
+	var node interface{} = s.doc
+	if ref == "#" || ref == "#/" {
+		return node, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("$ref %q is not a JSON pointer (anchors are not supported)", ref)
+	}
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		if unescaped, err := url.PathUnescape(token); err == nil {
+			token = unescaped
+		}
+		switch parent := node.(type) {
+		case map[string]interface{}:
+			child, ok := parent[token]
+			if !ok {
+				return nil, fmt.Errorf("$ref %q does not resolve", ref)
+			}
+			node = child
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(parent) {
+				return nil, fmt.Errorf("$ref %q does not resolve", ref)
+			}
+			node = parent[i]
+		default:
+			return nil, fmt.Errorf("$ref %q does not resolve", ref)
+		}
+	}
+	return node, nil
+}
+
+// resolveMap resolves node and expects a mapping; a missing node is an empty mapping.
+func (s *openAPISpec) resolveMap(node interface{}) (map[string]interface{}, error) {
+	if node == nil {
+		return map[string]interface{}{}, nil
+	}
+	v, err := s.resolve(node)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a mapping, got %T", v)
+	}
+	return m, nil
+}
+
+// lookup returns the operation documenting method and path, if any. Paths
+// are matched with and without the API's base path.
+func (s *openAPISpec) lookup(method, path string) *specOperation {
+	candidates := []string{path}
+	if rest, ok := strings.CutPrefix(path, s.basePath); ok && s.basePath != "" && (rest == "" || rest[0] == '/') {
+		candidates = append([]string{"/" + strings.TrimPrefix(rest, "/")}, path)
+	}
+	for _, candidate := range candidates {
+		for i := range s.operations {
+			if s.operations[i].Method == method && pathMatches(s.operations[i].Path, candidate) {
+				return &s.operations[i]
+			}
+		}
+	}
+	return nil
+}
+
+// documentedCodes lists the response keys of the operation in order.
+func (op *specOperation) documentedCodes() []string {
+	codes := make([]string, 0, len(op.Responses))
+	for code := range op.Responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// response picks the documented response for a Prefer code: the exact code,
+// its range ("4XX") or "default". Without a code the lowest 2xx response is used.
+func (op *specOperation) response(want string) (int, interface{}, error) {
+	codes := op.documentedCodes()
+	if want == "" {
+		for _, code := range codes {
+			if n, err := strconv.Atoi(code); err == nil && n >= 200 && n < 300 {
+				return n, op.Responses[code], nil
+			}
+		}
+		for _, code := range codes {
+			if n, err := strconv.Atoi(code); err == nil {
+				return n, op.Responses[code], nil
+			}
+		}
+		if resp, ok := op.Responses["default"]; ok {
+			return http.StatusOK, resp, nil
+		}
+		return 0, nil, fmt.Errorf("%s %s documents no responses", op.Method, op.Path)
+	}
+	status, err := strconv.Atoi(want)
+	if err != nil || status < 100 || status > 599 {
+		return 0, nil, fmt.Errorf("invalid code %q in Prefer header", want)
+	}
+	for _, key := range []string{want, want[:1] + "XX", want[:1] + "xx", "default"} {
+		if resp, ok := op.Responses[key]; ok {
+			return status, resp, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("%s %s does not document a %d response (documented: %s)", op.Method, op.Path, status, strings.Join(codes, ", "))
+}
+
+// parsePrefer reads the preferences of the Prefer headers (RFC 7240) into
+// name -> value; preference parameters after ';' are ignored.
+func parsePrefer(headers []string) map[string]string {
+	prefs := make(map[string]string)
+	for _, header := range headers {
+		for _, part := range strings.Split(header, ",") {
+			pref, _, _ := strings.Cut(part, ";")
+			name, value, _ := strings.Cut(strings.TrimSpace(pref), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				prefs[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	return prefs
+}
+
+// preferMiddleware answers requests carrying a code, example or dynamic
+// preference from the spec when the spec documents the operation, so error
+// branches can be tested on the real URL instead of the /error/* endpoints.
+func (s *openAPISpec) preferMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefs := parsePrefer(r.Header.Values("Prefer"))
+		_, code := prefs["code"]
+		_, example := prefs["example"]
+		dynamic := prefs["dynamic"] == "true"
+		if !code && !example && !dynamic {
+			next.ServeHTTP(w, r)
+			return
+		}
+		op := s.lookup(r.Method, r.URL.Path)
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.serve(w, r, op, prefs)
+	})
+}
+
+func (s *openAPISpec) serve(w http.ResponseWriter, r *http.Request, op *specOperation, prefs map[string]string) {
+	status, node, err := op.response(prefs["code"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Bad Request", err.Error())
+		return
+	}
+	resp, err := s.resolveMap(node)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Internal Server Error", fmt.Sprintf("%s %s %d: %v", op.Method, op.Path, status, err))
+		return
+	}
+	applied := []string{"code=" + strconv.Itoa(status)}
+
+	var body interface{}
+	mediaType, media := s.mediaFor(resp, r.Header.Get("Accept"))
+	if name := prefs["example"]; name != "" {
+		examples, _ := s.resolveMap(media["examples"])
+		ex, ok := examples[name]
+		if !ok {
+			names := make([]string, 0, len(examples))
+			for n := range examples {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			available := "it documents no named examples"
+			if len(names) > 0 {
+				available = "available: " + strings.Join(names, ", ")
+			}
+			writeError(w, r, http.StatusBadRequest, "Bad Request", fmt.Sprintf("%s %s %d has no example %q (%s)", op.Method, op.Path, status, name, available))
+			return
+		}
+		if body, err = s.exampleValue(ex); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "Internal Server Error", fmt.Sprintf("%s %s %d example %q: %v", op.Method, op.Path, status, name, err))
+			return
+		}
+		applied = append(applied, "example="+name)
+	} else if prefs["dynamic"] == "true" && media != nil {
+		g := &generator{rnd: requestRand(r)}
+		body = s.sample(media["schema"], "", g, 0)
+		applied = append(applied, "dynamic=true")
+	} else if media != nil {
+		body = s.staticExample(media)
+	}
+
+	headers, _ := s.resolveMap(resp["headers"])
+	for name, headerNode := range headers {
+		if value, ok := s.headerExample(headerNode); ok {
+			w.Header().Set(name, value)
+		}
+	}
+	w.Header().Set("Preference-Applied", strings.Join(applied, ", "))
+	log.Printf("Serving %s %s from %s (%s %s, %s)", r.Method, r.URL.Path, s.file, op.Method, op.Path, strings.Join(applied, ", "))
+
+	if media == nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(status)
+	if text, ok := body.(string); ok && !strings.Contains(mediaType, "json") {
+		w.Write([]byte(text))
+		return
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+// mediaFor picks the response content accepted by the Accept header,
+// JSON first. It returns a nil media object when the response has no body.
+func (s *openAPISpec) mediaFor(resp map[string]interface{}, accept string) (string, map[string]interface{}) {
+	content, _ := s.resolveMap(resp["content"])
+	types := make([]string, 0, len(content))
+	for t := range content {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		ji, jj := strings.Contains(types[i], "json"), strings.Contains(types[j], "json")
+		if ji != jj {
+			return ji
+		}
+		return types[i] < types[j]
+	})
+	for _, t := range types {
+		if accepts(accept, t) {
+			media, _ := s.resolveMap(content[t])
+			return t, media
+		}
+	}
+	if len(types) > 0 {
+		media, _ := s.resolveMap(content[types[0]])
+		return types[0], media
+	}
+	return "", nil
+}
+
+// staticExample is the media example, its first named example, or a value
+// built from the schema's examples, defaults and types.
+func (s *openAPISpec) staticExample(media map[string]interface{}) interface{} {
+	if ex, ok := media["example"]; ok {
+		return ex
+	}
+	if examples, _ := s.resolveMap(media["examples"]); len(examples) > 0 {
+		names := make([]string, 0, len(examples))
+		for name := range examples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		value, err := s.exampleValue(examples[names[0]])
+		if err != nil {
+			log.Printf("Ignoring example %q of %s: %v", names[0], s.file, err)
+		}
+		return value
+	}
+	return s.sample(media["schema"], "", nil, 0)
+}
+
+// exampleValue is the value of an Example Object, reading externalValue from
+// a file next to the spec.
+func (s *openAPISpec) exampleValue(node interface{}) (interface{}, error) {
+	ex, err := s.resolveMap(node)
+	if err != nil {
+		return nil, err
+	}
+	external, _ := ex["externalValue"].(string)
+	if _, ok := ex["value"]; ok || external == "" {
+		return ex["value"], nil
+	}
+	if strings.Contains(external, "://") {
+		return nil, fmt.Errorf("remote externalValue %q is not supported", external)
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(s.file), filepath.FromSlash(external)))
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return string(data), nil
+	}
+	return value, nil
+}
+
+func (s *openAPISpec) headerExample(node interface{}) (string, bool) {
+	header, err := s.resolveMap(node)
+	if err != nil {
+		return "", false
+	}
+	ex, ok := header["example"]
+	if !ok {
+		schema, _ := s.resolveMap(header["schema"])
+		if ex, ok = schema["example"]; !ok {
+			return "", false
+		}
+	}
+	return fmt.Sprint(ex), true
+}
+
+// sample builds a value for a schema. With a generator the value is random
+// fake data guided by the field name and format; without one it is the
+// schema's example or default, or a fixed placeholder for its type.
+func (s *openAPISpec) sample(node interface{}, field string, g *generator, depth int) interface{} {
+	schema, err := s.resolveMap(node)
+	if err != nil || depth > 8 {
+		return nil
+	}
+	if v, ok := schema["const"]; ok {
+		return v
+	}
+	if g == nil {
+		if v, ok := schema["example"]; ok {
+			return v
+		}
+		if examples, ok := schema["examples"].([]interface{}); ok && len(examples) > 0 {
+			return examples[0]
+		}
+		if v, ok := schema["default"]; ok {
+			return v
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && len(enum) > 0 {
+		if g == nil {
+			return enum[0]
+		}
+		return enum[g.rnd.Intn(len(enum))]
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if choices, ok := schema[key].([]interface{}); ok && len(choices) > 0 {
+			if g == nil {
+				return s.sample(choices[0], field, g, depth+1)
+			}
+			return s.sample(choices[g.rnd.Intn(len(choices))], field, g, depth+1)
+		}
+	}
+	if parts, ok := schema["allOf"].([]interface{}); ok {
+		merged := make(map[string]interface{})
+		for _, part := range parts {
+			if obj, ok := s.sample(part, field, g, depth+1).(map[string]interface{}); ok {
+				for k, v := range obj {
+					merged[k] = v
+				}
+			}
+		}
+		return merged
+	}
+
+	format, _ := schema["format"].(string)
+	switch schemaType(schema) {
+	case "object":
+		props, _ := s.resolveMap(schema["properties"])
+		names := make([]string, 0, len(props))
+		for name := range props {
+			names = append(names, name)
+		}
+		sort.Strings(names) // a fixed order keeps seeded values reproducible
+		obj := make(map[string]interface{}, len(props))
+		for _, name := range names {
+			obj[name] = s.sample(props[name], name, g, depth+1)
+		}
+		return obj
+	case "array":
+		var items []interface{}
+		prefix, _ := schema["prefixItems"].([]interface{})
+		for _, item := range prefix {
+			items = append(items, s.sample(item, field, g, depth+1))
+		}
+		if schema["items"] == false || (len(prefix) > 0 && schema["items"] == nil) {
+			return items
+		}
+		n := 1
+		if g != nil {
+			n = 1 + g.rnd.Intn(3)
+		}
+		for i := 0; i < n; i++ {
+			items = append(items, s.sample(schema["items"], field, g, depth+1))
+		}
+		return items
+	case "integer", "number":
+		v := 0.0
+		if g != nil {
+			v = g.number(strings.ToLower(field), 0)
+		}
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			v = min
+		}
+		if max, ok := schema["maximum"].(float64); ok && v > max {
+			v = max
+		}
+		// OpenAPI 3.1 (JSON Schema 2020-12) makes the exclusive bounds numbers
+		if min, ok := schema["exclusiveMinimum"].(float64); ok && v <= min {
+			v = min + 1
+		}
+		if max, ok := schema["exclusiveMaximum"].(float64); ok && v >= max {
+			v = max - 1
+		}
+		if schemaType(schema) == "integer" {
+			v = math.Trunc(v)
+		}
+		return v
+	case "boolean":
+		return g != nil && g.rnd.Intn(2) == 0
+	case "string":
+		return sampleString(field, format, g)
+	}
+	return nil
+}
+
+// schemaType is the schema's type, inferred as "object" when it only lists
+// properties. Of an OpenAPI 3.1 type list (["string", "null"]) the first
+// non-null type is used.
+func schemaType(schema map[string]interface{}) string {
+	switch t := schema["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		for _, item := range t {
+			if name, ok := item.(string); ok && name != "null" {
+				return name
+			}
+		}
+		return "null"
+	}
+	if _, ok := schema["properties"]; ok {
+		return "object"
+	}
+	return ""
+}
+
+func sampleString(field, format string, g *generator) string {
+	if g == nil {
+		switch format {
+		case "date-time":
+			return "2024-01-01T00:00:00Z"
+		case "date":
+			return "2024-01-01"
+		case "email":
+			return "user@example.com"
+		case "uuid":
+			return "00000000-0000-0000-0000-000000000000"
+		case "uri", "url":
+			return "https://example.com"
+		}
+		return "string"
+	}
+	g.seq++
+	switch format {
+	case "date-time", "date":
+		base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		t := base.Add(time.Duration(g.rnd.Int63n(int64(365 * 24 * time.Hour)))).Truncate(time.Second)
+		if format == "date" {
+			return t.Format("2006-01-02")
+		}
+		return t.Format(time.RFC3339)
+	case "email":
+		field = "email"
+	case "uuid":
+		return fmt.Sprintf("%08x-%04x-4%03x-%04x-%012x", g.rnd.Uint32(), g.rnd.Intn(1<<16), g.rnd.Intn(1<<12), 0x8000|g.rnd.Intn(1<<14), g.rnd.Int63n(1<<48))
+	case "uri", "url":
+		field = "url"
+	}
+	if field == "" {
+		field = "item"
+	}
+	return g.text(strings.ToLower(field), "", g.pick(fakeFirstNames), g.pick(fakeLastNames), true)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// bundledKey is the top-level extension holding the documents referenced
+// through external $refs, keyed by their path relative to the spec.
+const bundledKey = "x-bundled"
+
+// specBundler rewrites external references ("common.yaml#/components/schemas/Error",
+// "schemas/user.json") into local references to copies of the referenced
+// files kept under x-bundled, so every $ref resolves within one document.
+type specBundler struct {
+	main string // absolute path of the spec
+	keys map[string]string
+	docs map[string]interface{}
+}
+
+// bundleExternalRefs bundles the files referenced by doc and returns how many
+// there were. Remote (http) references are rejected.
+func bundleExternalRefs(doc map[string]interface{}, file string) (int, error) {
+	main, err := filepath.Abs(file)
+	if err != nil {
+		return 0, err
+	}
+	b := &specBundler{main: main, keys: make(map[string]string), docs: make(map[string]interface{})}
+	if err := b.rewrite(doc, main); err != nil {
+		return 0, err
+	}
+	if len(b.docs) > 0 {
+		doc[bundledKey] = b.docs
+	}
+	return len(b.docs), nil
+}
+
+func (b *specBundler) rewrite(node interface{}, file string) error {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["$ref"].(string); ok {
+			local, err := b.ref(ref, file)
+			if err != nil {
+				return err
+			}
+			v["$ref"] = local
+		}
+		for key, child := range v {
+			if key == "example" || key == "value" || key == "default" || key == "enum" || key == "const" {
+				continue
+			}
+			if err := b.rewrite(child, file); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if err := b.rewrite(child, file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ref turns a reference found in file into a pointer into the bundled document.
+func (b *specBundler) ref(ref, file string) (string, error) {
+	target, fragment, _ := strings.Cut(ref, "#")
+	if strings.Contains(target, "://") {
+		return "", fmt.Errorf("remote $ref %q is not supported; save the file next to the spec and reference it by path", ref)
+	}
+	if target == "" {
+		if file == b.main {
+			return ref, nil
+		}
+		return "#/" + bundledKey + "/" + escapePointerToken(b.keys[file]) + fragment, nil
+	}
+
+	path := filepath.Join(filepath.Dir(file), filepath.FromSlash(target))
+	key, ok := b.keys[path]
+	if !ok {
+		if path == b.main {
+			return "#" + fragment, nil
+		}
+		rel, err := filepath.Rel(filepath.Dir(b.main), path)
+		if err != nil {
+			rel = path
+		}
+		key = filepath.ToSlash(rel)
+		doc, _, err := readSpecFile(path)
+		if err != nil {
+			return "", fmt.Errorf("$ref %q: %w", ref, err)
+		}
+		b.keys[path] = key
+		b.docs[key] = doc
+		if err := b.rewrite(doc, path); err != nil {
+			return "", err
+		}
+	}
+	return "#/" + bundledKey + "/" + escapePointerToken(key) + fragment, nil
+}
+
+func escapePointerToken(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// unsupportedKeywords are constructs the server cannot honour, reported at
+// startup rather than silently ignored.
+var unsupportedKeywords = map[string]string{
+	"$dynamicRef":           "dynamic references are not resolved",
+	"$dynamicAnchor":        "dynamic anchors are not resolved",
+	"$anchor":               "anchors are not resolved; use JSON pointer references",
+	"$id":                   "schema identifiers are not used to resolve references",
+	"if":                    "conditional schemas are ignored when generating responses",
+	"dependentSchemas":      "dependent schemas are ignored when generating responses",
+	"patternProperties":     "pattern properties are ignored when generating responses",
+	"unevaluatedProperties": "unevaluatedProperties is ignored when generating responses",
+	"not":                   "negated schemas are ignored when generating responses",
+	"callbacks":             "callbacks are not sent",
+	"links":                 "links are not followed",
+	"webhooks":              "webhooks are not sent",
+}
+
+// namedChildren are keys whose values map user-chosen names to objects, so
+// their keys are never keywords.
+var namedChildren = map[string]bool{
+	"properties": true, "patternProperties": true, "$defs": true, "definitions": true, "schemas": true,
+	"paths": true, "responses": true, "headers": true, "content": true, "parameters": true, "examples": true,
+	"requestBodies": true, "securitySchemes": true, "encoding": true, "variables": true, "dependentSchemas": true,
+	"callbacks": true, "links": true, "webhooks": true, "pathItems": true, bundledKey: true,
+}
+
+// unsupportedConstructs lists, once per keyword, the constructs of doc that
+// the server ignores, with the first place each was found.
+func unsupportedConstructs(doc map[string]interface{}) []string {
+	type finding struct {
+		at    string
+		count int
+	}
+	found := make(map[string]*finding)
+	var walk func(node interface{}, at string, named bool)
+	walk = func(node interface{}, at string, named bool) {
+		switch v := node.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				if strings.HasPrefix(key, "x-") && key != bundledKey {
+					continue
+				}
+				childAt := at + "/" + escapePointerToken(key)
+				if !named {
+					if _, ok := unsupportedKeywords[key]; ok {
+						if f := found[key]; f != nil {
+							f.count++
+						} else {
+							found[key] = &finding{at: childAt, count: 1}
+						}
+					}
+					if key == "example" || key == "value" || key == "default" || key == "enum" || key == "const" || (key == "examples" && isList(child)) {
+						continue
+					}
+				}
+				walk(child, childAt, !named && namedChildren[key])
+			}
+		case []interface{}:
+			for i, child := range v {
+				walk(child, fmt.Sprintf("%s/%d", at, i), false)
+			}
+		}
+	}
+	walk(doc, "#", false)
+
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	warnings := make([]string, 0, len(keys))
+	for _, key := range keys {
+		f := found[key]
+		where := f.at
+		if f.count > 1 {
+			where += fmt.Sprintf(" and %d more place(s)", f.count-1)
+		}
+		warnings = append(warnings, fmt.Sprintf("%s (%s): %s", key, where, unsupportedKeywords[key]))
+	}
+	return warnings
+}
+
+func isList(v interface{}) bool {
+	_, ok := v.([]interface{})
+	return ok
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// valueAt follows keys through nested maps.
+func valueAt(doc interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, _ := doc.(map[string]interface{})
+		doc = m[key]
+	}
+	return doc
+}
+
+// bundledRef is the $ref wanted at a path of the bundled spec.
+type bundledRef struct {
+	at   []string
+	want string
+}
+
+func TestBundleExternalRefs(t *testing.T) {
+	const userSchema = `{"type": "object", "properties": {"error": {"$ref": "../common.yaml#/components/schemas/Error"}, "self": {"$ref": "#/definitions/Id"}}, "definitions": {"Id": {"type": "string"}}}`
+	tests := []struct {
+		name    string
+		spec    string
+		files   map[string]string
+		bundled int
+		refs    []bundledRef
+		err     string
+	}{
+		{
+			name:    "local refs are kept",
+			spec:    "components: {schemas: {A: {$ref: '#/components/schemas/B'}, B: {type: string}}}",
+			refs:    []bundledRef{{[]string{"components", "schemas", "A"}, "#/components/schemas/B"}},
+			bundled: 0,
+		},
+		{
+			name:  "file with fragment",
+			spec:  "components: {schemas: {E: {$ref: 'common.yaml#/components/schemas/Error'}}}",
+			files: map[string]string{"common.yaml": "components: {schemas: {Error: {type: object}}}"},
+			refs: []bundledRef{
+				{[]string{"components", "schemas", "E"}, "#/x-bundled/common.yaml/components/schemas/Error"},
+			},
+			bundled: 1,
+		},
+		{
+			name: "nested file refs resolve relative to their file",
+			spec: "components: {schemas: {U: {$ref: 'schemas/user.json'}}}",
+			files: map[string]string{
+				"schemas/user.json": userSchema,
+				"common.yaml":       "components: {schemas: {Error: {type: object}}}",
+			},
+			refs: []bundledRef{
+				{[]string{"components", "schemas", "U"}, "#/x-bundled/schemas~1user.json"},
+				{[]string{"x-bundled", "schemas/user.json", "properties", "error"}, "#/x-bundled/common.yaml/components/schemas/Error"},
+				{[]string{"x-bundled", "schemas/user.json", "properties", "self"}, "#/x-bundled/schemas~1user.json/definitions/Id"},
+			},
+			bundled: 2,
+		},
+		{
+			name:  "refs back into the spec",
+			spec:  "components: {schemas: {A: {$ref: 'a.yaml'}, B: {type: string}}}",
+			files: map[string]string{"a.yaml": "items: {$ref: 'spec.yaml#/components/schemas/B'}"},
+			refs: []bundledRef{
+				{[]string{"components", "schemas", "A"}, "#/x-bundled/a.yaml"},
+				{[]string{"x-bundled", "a.yaml", "items"}, "#/components/schemas/B"},
+			},
+			bundled: 1,
+		},
+		{
+			name: "cycles between files",
+			spec: "components: {schemas: {A: {$ref: 'a.yaml'}}}",
+			files: map[string]string{
+				"a.yaml": "properties: {b: {$ref: 'b.yaml'}}",
+				"b.yaml": "properties: {a: {$ref: 'a.yaml'}}",
+			},
+			refs: []bundledRef{
+				{[]string{"x-bundled", "a.yaml", "properties", "b"}, "#/x-bundled/b.yaml"},
+				{[]string{"x-bundled", "b.yaml", "properties", "a"}, "#/x-bundled/a.yaml"},
+			},
+			bundled: 2,
+		},
+		{
+			name:    "examples are not rewritten",
+			spec:    "components: {schemas: {A: {type: object, example: {$ref: 'missing.yaml'}}}}",
+			refs:    []bundledRef{{[]string{"components", "schemas", "A", "example"}, "missing.yaml"}},
+			bundled: 0,
+		},
+		{
+			name: "remote refs are rejected",
+			spec: "components: {schemas: {A: {$ref: 'https://example.com/a.yaml'}}}",
+			err:  "remote $ref",
+		},
+		{
+			name: "missing files are reported",
+			spec: "components: {schemas: {A: {$ref: 'missing.yaml'}}}",
+			err:  `$ref "missing.yaml"`,
+		},
+	}
+	for _, tt := range tests {
+		files := map[string]string{"spec.yaml": tt.spec}
+		for name, content := range tt.files {
+			files[name] = content
+		}
+		dir := writeResponseFiles(t, files)
+		raw, _, err := readSpecFile(filepath.Join(dir, "spec.yaml"))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		doc := raw.(map[string]interface{})
+		n, err := bundleExternalRefs(doc, filepath.Join(dir, "spec.yaml"))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if n != tt.bundled {
+			t.Errorf("%s: bundled %d file(s), want %d", tt.name, n, tt.bundled)
+		}
+		for _, ref := range tt.refs {
+			if got, _ := valueAt(doc, append(ref.at, "$ref")...).(string); got != ref.want {
+				t.Errorf("%s: $ref at %s is %q, want %q", tt.name, strings.Join(ref.at, " > "), got, ref.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// record is a single stored resource as decoded from JSON.
+// Stored records are never mutated in place: every update stores a new map,
+// so a record handed out under the read lock can be encoded or expanded safely.
+type record = map[string]interface{}
+
+var errNotFound = errors.New("record not found")
+
+// conflictError is returned when an operation would violate a declared rule,
+// e.g. deleting a user that is still referenced by a restricting relation.
+type conflictError struct {
+	msg string
+}
+
+func (e *conflictError) Error() string { return e.msg }
+
+// storeConfig is the on-disk description of the stateful collections (STORE_CONFIG).
+type storeConfig struct {
+	Collections map[string]collectionConfig `json:"collections"`
+	Relations   []relation                  `json:"relations"`
+}
+
+type collectionConfig struct {
+	// Seed is a response file (relative to the responses directory) holding
+	// either an array of records or a single record.
+	Seed string `json:"seed"`
+}
+
+// collection keeps records in insertion order with an id index.
+type collection struct {
+	name   string
+	ids    []string
+	byID   map[string]record
+	prefix string
+	width  int
+	nextID int
+}
+
+// store holds all stateful collections and the relations between them.
+type store struct {
+	mu          sync.RWMutex
+	collections map[string]*collection
+	relations   []relation
+	rules       map[string]*collectionRules
+}
+
+func newStore() *store {
+	return &store{collections: make(map[string]*collection)}
+}
+
+// loadStore reads the store configuration and seeds every collection from its response file.
+func loadStore(configPath, responsesDir string) (*store, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var cfg storeConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", configPath, err)
+	}
+
+	s := newStore()
+	for name, cc := range cfg.Collections {
+		c := newCollection(name)
+		if cc.Seed != "" {
+			records, err := readSeedFile(filepath.Join(responsesDir, cc.Seed))
+			if err != nil {
+				return nil, fmt.Errorf("seeding %s: %w", name, err)
+			}
+			for _, rec := range records {
+				c.insert(rec)
+			}
+		}
+		s.collections[name] = c
+	}
+
+	for i, rel := range cfg.Relations {
+		if err := rel.validate(s); err != nil {
+			return nil, fmt.Errorf("relation %d: %w", i, err)
+		}
+		cfg.Relations[i] = rel.withDefaults()
+	}
+	s.relations = cfg.Relations
+	return s, nil
+}
+
+// readSeedFile accepts a JSON array of objects or a single JSON object.
+func readSeedFile(path string) ([]record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var many []record
+	if err := json.Unmarshal(data, &many); err == nil {
+		return many, nil
+	}
+	var one record
+	if err := json.Unmarshal(data, &one); err != nil {
+		return nil, fmt.Errorf("%s is neither a JSON object nor an array of objects", path)
+	}
+	return []record{one}, nil
+}
+
+func newCollection(name string) *collection {
+	return &collection{
+		name:   name,
+		byID:   make(map[string]record),
+		prefix: name + "-",
+		width:  3,
+		nextID: 1,
+	}
+}
+
+// insert stores rec, assigning an id when it has none. The id format
+// ("user-004") follows the ids already present in the collection.
+func (c *collection) insert(rec record) record {
+	id := idOf(rec)
+	if id == "" {
+		id = fmt.Sprintf("%s%0*d", c.prefix, c.width, c.nextID)
+		for c.byID[id] != nil {
+			c.nextID++
+			id = fmt.Sprintf("%s%0*d", c.prefix, c.width, c.nextID)
+		}
+		rec = copyRecord(rec)
+		rec["id"] = id
+	}
+	c.learnID(id)
+	if _, exists := c.byID[id]; !exists {
+		c.ids = append(c.ids, id)
+	}
+	c.byID[id] = rec
+	return rec
+}
+
+// learnID updates the id prefix and counter from an id like "prod-007".
+func (c *collection) learnID(id string) {
+	i := strings.LastIndex(id, "-")
+	if i < 0 {
+		return
+	}
+	n, err := strconv.Atoi(id[i+1:])
+	if err != nil {
+		return
+	}
+	if len(c.ids) == 0 {
+		c.prefix = id[:i+1]
+		c.width = len(id) - i - 1
+	}
+	if id[:i+1] == c.prefix && n >= c.nextID {
+		c.nextID = n + 1
+	}
+}
+
+func (c *collection) remove(id string) {
+	if _, ok := c.byID[id]; !ok {
+		return
+	}
+	delete(c.byID, id)
+	for i, existing := range c.ids {
+		if existing == id {
+			c.ids = append(c.ids[:i], c.ids[i+1:]...)
+			break
+		}
+	}
+}
+
+func (c *collection) all() []record {
+	out := make([]record, 0, len(c.ids))
+	for _, id := range c.ids {
+		out = append(out, c.byID[id])
+	}
+	return out
+}
+
+func idOf(rec record) string {
+	switch v := rec["id"].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+func copyRecord(rec record) record {
+	out := make(record, len(rec)+1)
+	for k, v := range rec {
+		out[k] = v
+	}
+	return out
+}
+
+// clone copies the collections so the copy can change independently of s.
+// Records are shared because they are never mutated in place.
+func (s *store) clone() *store {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := &store{
+		collections: make(map[string]*collection, len(s.collections)),
+		relations:   s.relations,
+		rules:       s.rules,
+	}
+	for name, c := range s.collections {
+		cp := *c
+		cp.ids = append([]string(nil), c.ids...)
+		cp.byID = make(map[string]record, len(c.byID))
+		for id, rec := range c.byID {
+			cp.byID[id] = rec
+		}
+		out.collections[name] = &cp
+	}
+	return out
+}
+
+// resetTo replaces the records of s with a copy of baseline's and returns the
+// number of records per collection before and after.
+func (s *store) resetTo(baseline *store) (before, after map[string]int) {
+	fresh := baseline.clone()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before = recordCounts(s.collections)
+	s.collections = fresh.collections
+	return before, recordCounts(s.collections)
+}
+
+// recordCounts returns the number of records per collection.
+func (s *store) recordCounts() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return recordCounts(s.collections)
+}
+
+func recordCounts(collections map[string]*collection) map[string]int {
+	counts := make(map[string]int, len(collections))
+	for name, c := range collections {
+		counts[name] = len(c.ids)
+	}
+	return counts
+}
+
+// collectionNames returns the configured collection names in a stable order.
+func (s *store) collectionNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.collections))
+	for name := range s.collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *store) list(name string) ([]record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c := s.collections[name]
+	if c == nil {
+		return nil, errNotFound
+	}
+	return c.all(), nil
+}
+
+func (s *store) get(name, id string) (record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c := s.collections[name]
+	if c == nil || c.byID[id] == nil {
+		return nil, errNotFound
+	}
+	return c.byID[id], nil
+}
+
+func (s *store) create(name string, rec record) (record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.collections[name]
+	if c == nil {
+		return nil, errNotFound
+	}
+	if id := idOf(rec); id != "" && c.byID[id] != nil {
+		return nil, &conflictError{msg: fmt.Sprintf("%s %s already exists", name, id)}
+	}
+	rec = copyRecord(rec)
+	if _, ok := rec["created_at"]; !ok {
+		rec["created_at"] = clock.Now().UTC().Format(time.RFC3339)
+	}
+	rec, refs, changed, err := s.applyRulesLocked(name, nil, rec)
+	if err != nil {
+		return nil, err
+	}
+	s.commitLocked(refs, changed)
+	return c.insert(rec), nil
+}
+
+// update replaces (merge=false) or merges into (merge=true) an existing record.
+func (s *store) update(name, id string, rec record, merge bool) (record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.collections[name]
+	if c == nil || c.byID[id] == nil {
+		return nil, errNotFound
+	}
+	var updated record
+	if merge {
+		updated = copyRecord(c.byID[id])
+		for k, v := range rec {
+			updated[k] = v
+		}
+	} else {
+		updated = copyRecord(rec)
+		if created, ok := c.byID[id]["created_at"]; ok {
+			if _, set := updated["created_at"]; !set {
+				updated["created_at"] = created
+			}
+		}
+	}
+	updated["id"] = id
+	updated, refs, changed, err := s.applyRulesLocked(name, c.byID[id], updated)
+	if err != nil {
+		return nil, err
+	}
+	s.commitLocked(refs, changed)
+	c.byID[id] = updated
+	return updated, nil
+}
+
+// commitLocked stores records changed as a side effect of a rule, e.g. reduced stock.
+func (s *store) commitLocked(refs []ref, changed []record) {
+	for i, r := range refs {
+		s.collections[r.collection].byID[r.id] = changed[i]
+	}
+}
+
+// delete removes a record, applying the onDelete policy of every relation
+// that points at it. Nothing is removed when a restricting relation blocks it.
+func (s *store) delete(name, id string) ([]ref, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.collections[name]
+	if c == nil || c.byID[id] == nil {
+		return nil, errNotFound
+	}
+	doomed, err := s.planDeleteLocked(ref{collection: name, id: id})
+	if err != nil {
+		return nil, err
+	}
+	refs, changed := s.releaseStockLocked(doomed)
+	for _, d := range doomed {
+		s.collections[d.collection].remove(d.id)
+	}
+	s.commitLocked(refs, changed)
+	return doomed, nil
+}
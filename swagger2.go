@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// convertSwagger2 converts a Swagger 2.0 document to the OpenAPI 3.0 layout:
+// definitions, responses and parameters move under components, response
+// schemas and examples move under content for each produced media type,
+// body and form parameters become request bodies and basePath becomes the
+// server URL. Vendor extensions are kept.
+func (s *openAPISpec) convertSwagger2() (map[string]interface{}, error) {
+	in := s.doc
+	produces := stringList(in["produces"], "application/json")
+	consumes := stringList(in["consumes"], "application/json")
+
+	out := map[string]interface{}{"openapi": "3.0.3", "info": in["info"]}
+	for key, v := range in {
+		if strings.HasPrefix(key, "x-") || key == "tags" || key == "externalDocs" || key == "security" {
+			out[key] = v
+		}
+	}
+	if basePath, _ := in["basePath"].(string); basePath != "" && basePath != "/" {
+		out["servers"] = []interface{}{map[string]interface{}{"url": basePath}}
+	}
+
+	components := make(map[string]interface{})
+	if defs, ok := in["definitions"].(map[string]interface{}); ok {
+		components["schemas"] = defs
+	}
+	if params, ok := in["parameters"].(map[string]interface{}); ok {
+		converted := make(map[string]interface{}, len(params))
+		for name, p := range params {
+			if param, _ := p.(map[string]interface{}); param != nil && param["in"] != "body" && param["in"] != "formData" {
+				converted[name] = convertSwagger2Parameter(param)
+			}
+		}
+		components["parameters"] = converted
+	}
+	if responses, ok := in["responses"].(map[string]interface{}); ok {
+		converted := make(map[string]interface{}, len(responses))
+		for name, resp := range responses {
+			c, err := s.convertSwagger2Response(resp, produces)
+			if err != nil {
+				return nil, fmt.Errorf("responses %s: %w", name, err)
+			}
+			converted[name] = c
+		}
+		components["responses"] = converted
+	}
+	if schemes, ok := in["securityDefinitions"].(map[string]interface{}); ok {
+		components["securitySchemes"] = convertSwagger2Security(schemes)
+	}
+	out["components"] = components
+
+	paths := make(map[string]interface{})
+	inPaths, _ := in["paths"].(map[string]interface{})
+	for path, node := range inPaths {
+		item, err := s.resolveMap(node)
+		if err != nil {
+			return nil, fmt.Errorf("paths %s: %w", path, err)
+		}
+		shared, _ := item["parameters"].([]interface{})
+		newItem := make(map[string]interface{})
+		for method, opNode := range item {
+			if !specMethods[method] {
+				if method != "parameters" {
+					newItem[method] = opNode
+				}
+				continue
+			}
+			op, err := s.resolveMap(opNode)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+			converted, err := s.convertSwagger2Operation(op, shared, stringList(op["produces"], produces...), stringList(op["consumes"], consumes...))
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+			newItem[method] = converted
+		}
+		paths[path] = newItem
+	}
+	out["paths"] = paths
+	if bundled, ok := in[bundledKey]; ok {
+		out[bundledKey] = bundled
+	}
+
+	rewriteSwagger2Refs(out)
+	return out, nil
+}
+
+func (s *openAPISpec) convertSwagger2Operation(op map[string]interface{}, shared []interface{}, produces, consumes []string) (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+	for key, v := range op {
+		switch key {
+		case "parameters", "responses", "produces", "consumes", "schemes":
+		default:
+			out[key] = v
+		}
+	}
+
+	// Operation parameters override path-level ones with the same name and location
+	byKey := make(map[string]map[string]interface{})
+	var order []string
+	for _, list := range [][]interface{}{shared, listOf(op["parameters"])} {
+		for _, node := range list {
+			param, err := s.resolveMap(node)
+			if err != nil {
+				return nil, err
+			}
+			key := fmt.Sprint(param["in"], ":", param["name"])
+			if _, ok := byKey[key]; !ok {
+				order = append(order, key)
+			}
+			byKey[key] = param
+		}
+	}
+	var params []interface{}
+	form := map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	var formRequired []interface{}
+	multipart := false
+	for _, key := range order {
+		param := byKey[key]
+		switch param["in"] {
+		case "body":
+			content := make(map[string]interface{})
+			for _, mediaType := range consumes {
+				content[mediaType] = map[string]interface{}{"schema": param["schema"]}
+			}
+			body := map[string]interface{}{"content": content}
+			if param["required"] == true {
+				body["required"] = true
+			}
+			if desc, ok := param["description"]; ok {
+				body["description"] = desc
+			}
+			out["requestBody"] = body
+		case "formData":
+			name, _ := param["name"].(string)
+			form["properties"].(map[string]interface{})[name] = swagger2Schema(param)
+			if param["required"] == true {
+				formRequired = append(formRequired, name)
+			}
+			if param["type"] == "file" {
+				multipart = true
+			}
+		default:
+			params = append(params, convertSwagger2Parameter(param))
+		}
+	}
+	if len(form["properties"].(map[string]interface{})) > 0 {
+		if len(formRequired) > 0 {
+			form["required"] = formRequired
+		}
+		mediaType := "application/x-www-form-urlencoded"
+		if multipart {
+			mediaType = "multipart/form-data"
+		}
+		out["requestBody"] = map[string]interface{}{"content": map[string]interface{}{mediaType: map[string]interface{}{"schema": form}}}
+	}
+	if len(params) > 0 {
+		out["parameters"] = params
+	}
+
+	responses := make(map[string]interface{})
+	inResponses, err := s.resolveMap(op["responses"])
+	if err != nil {
+		return nil, err
+	}
+	for code, resp := range inResponses {
+		if strings.HasPrefix(code, "x-") {
+			continue
+		}
+		converted, err := s.convertSwagger2Response(resp, produces)
+		if err != nil {
+			return nil, fmt.Errorf("response %s: %w", code, err)
+		}
+		responses[code] = converted
+	}
+	out["responses"] = responses
+	return out, nil
+}
+
+// convertSwagger2Response moves the schema and per-media-type examples of a
+// response under content. References to shared responses are kept.
+func (s *openAPISpec) convertSwagger2Response(node interface{}, produces []string) (interface{}, error) {
+	if m, ok := node.(map[string]interface{}); ok {
+		if ref, ok := m["$ref"].(string); ok && strings.HasPrefix(ref, "#/responses/") {
+			return m, nil
+		}
+	}
+	resp, err := s.resolveMap(node)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{"description": resp["description"]}
+	if out["description"] == nil {
+		out["description"] = ""
+	}
+	if headers, ok := resp["headers"].(map[string]interface{}); ok {
+		converted := make(map[string]interface{}, len(headers))
+		for name, h := range headers {
+			header, _ := h.(map[string]interface{})
+			c := map[string]interface{}{"schema": swagger2Schema(header)}
+			if desc, ok := header["description"]; ok {
+				c["description"] = desc
+			}
+			converted[name] = c
+		}
+		out["headers"] = converted
+	}
+	examples, _ := resp["examples"].(map[string]interface{})
+	content := make(map[string]interface{})
+	if schema, ok := resp["schema"]; ok {
+		for _, mediaType := range produces {
+			content[mediaType] = map[string]interface{}{"schema": schema}
+		}
+	}
+	for mediaType, example := range examples {
+		media, _ := content[mediaType].(map[string]interface{})
+		if media == nil {
+			media = make(map[string]interface{})
+			if schema, ok := resp["schema"]; ok {
+				media["schema"] = schema
+			}
+			content[mediaType] = media
+		}
+		media["example"] = example
+	}
+	if len(content) > 0 {
+		out["content"] = content
+	}
+	return out, nil
+}
+
+// convertSwagger2Parameter moves the type keywords of a non-body parameter into a schema.
+func convertSwagger2Parameter(param map[string]interface{}) map[string]interface{} {
+	if ref, ok := param["$ref"]; ok {
+		return map[string]interface{}{"$ref": ref}
+	}
+	out := make(map[string]interface{})
+	for _, key := range []string{"name", "in", "description", "required", "allowEmptyValue"} {
+		if v, ok := param[key]; ok {
+			out[key] = v
+		}
+	}
+	out["schema"] = swagger2Schema(param)
+	return out
+}
+
+// swagger2Schema builds a schema from the inline type keywords of a Swagger 2.0
+// parameter or header; files become binary strings.
+func swagger2Schema(param map[string]interface{}) map[string]interface{} {
+	schema := make(map[string]interface{})
+	for _, key := range []string{"type", "format", "items", "enum", "default", "minimum", "maximum", "exclusiveMinimum",
+		"exclusiveMaximum", "minLength", "maxLength", "pattern", "minItems", "maxItems", "uniqueItems", "multipleOf"} {
+		if v, ok := param[key]; ok {
+			schema[key] = v
+		}
+	}
+	if schema["type"] == "file" {
+		schema["type"], schema["format"] = "string", "binary"
+	}
+	return schema
+}
+
+func convertSwagger2Security(schemes map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(schemes))
+	for name, node := range schemes {
+		scheme, _ := node.(map[string]interface{})
+		switch scheme["type"] {
+		case "basic":
+			out[name] = map[string]interface{}{"type": "http", "scheme": "basic"}
+		case "oauth2":
+			flow := map[string]interface{}{"scopes": scheme["scopes"]}
+			if scheme["scopes"] == nil {
+				flow["scopes"] = map[string]interface{}{}
+			}
+			for _, key := range []string{"authorizationUrl", "tokenUrl"} {
+				if v, ok := scheme[key]; ok {
+					flow[key] = v
+				}
+			}
+			flows := map[string]string{"implicit": "implicit", "password": "password", "application": "clientCredentials", "accessCode": "authorizationCode"}
+			flowName := flows[fmt.Sprint(scheme["flow"])]
+			if flowName == "" {
+				flowName = "implicit"
+			}
+			out[name] = map[string]interface{}{"type": "oauth2", "flows": map[string]interface{}{flowName: flow}}
+		default:
+			out[name] = scheme
+		}
+	}
+	return out
+}
+
+// rewriteSwagger2Refs points Swagger 2.0 references at their OpenAPI 3.0 locations.
+func rewriteSwagger2Refs(node interface{}) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["$ref"].(string); ok {
+			for from, to := range map[string]string{"#/definitions/": "#/components/schemas/", "#/responses/": "#/components/responses/", "#/parameters/": "#/components/parameters/"} {
+				if rest, ok := strings.CutPrefix(ref, from); ok {
+					v["$ref"] = to + rest
+				}
+			}
+		}
+		for key, child := range v {
+			if key != "example" && key != "default" && key != "enum" {
+				rewriteSwagger2Refs(child)
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			rewriteSwagger2Refs(child)
+		}
+	}
+}
+
+// stringList returns v as a list of strings, or def when it is missing or empty.
+func stringList(v interface{}, def ...string) []string {
+	list, _ := v.([]interface{})
+	var out []string
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		return def
+	}
+	return out
+}
+
+func listOf(v interface{}) []interface{} {
+	list, _ := v.([]interface{})
+	return list
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// convertedValue is the JSON wanted at a path of the converted document.
+type convertedValue struct {
+	at   []string
+	want string
+}
+
+func TestConvertSwagger2(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   string
+		values []convertedValue
+	}{
+		{
+			name: "basePath becomes the server",
+			spec: `{swagger: "2.0", info: {title: t}, basePath: /v1, x-owner: team, paths: {}}`,
+			values: []convertedValue{
+				{[]string{"openapi"}, `"3.0.3"`},
+				{[]string{"servers"}, `[{"url":"/v1"}]`},
+				{[]string{"x-owner"}, `"team"`},
+			},
+		},
+		{
+			name:   "root basePath adds no server",
+			spec:   `{swagger: "2.0", basePath: /, paths: {}}`,
+			values: []convertedValue{{[]string{"servers"}, `null`}},
+		},
+		{
+			name: "response schemas move under content with rewritten refs",
+			spec: `{swagger: "2.0", produces: [application/json, application/xml],
+				definitions: {User: {type: object}},
+				paths: {/users: {get: {responses: {"200": {description: ok, schema: {$ref: "#/definitions/User"}}}}}}}`,
+			values: []convertedValue{
+				{[]string{"components", "schemas", "User"}, `{"type":"object"}`},
+				{[]string{"paths", "/users", "get", "responses", "200", "content"},
+					`{"application/json":{"schema":{"$ref":"#/components/schemas/User"}},"application/xml":{"schema":{"$ref":"#/components/schemas/User"}}}`},
+			},
+		},
+		{
+			name: "operation produces and examples",
+			spec: `{swagger: "2.0", paths: {/users: {get: {produces: [text/csv], responses: {"200": {description: ok,
+				schema: {type: string}, examples: {text/csv: "id\n"}}}}}}}`,
+			values: []convertedValue{
+				{[]string{"paths", "/users", "get", "responses", "200", "content"}, `{"text/csv":{"example":"id\n","schema":{"type":"string"}}}`},
+			},
+		},
+		{
+			name: "body parameter becomes the request body",
+			spec: `{swagger: "2.0", consumes: [application/json],
+				paths: {/users: {post: {parameters: [{in: body, name: user, required: true, description: new user, schema: {type: object}}],
+				responses: {"201": {description: created}}}}}}`,
+			values: []convertedValue{
+				{[]string{"paths", "/users", "post", "requestBody"},
+					`{"content":{"application/json":{"schema":{"type":"object"}}},"description":"new user","required":true}`},
+				{[]string{"paths", "/users", "post", "parameters"}, `null`},
+				{[]string{"paths", "/users", "post", "responses", "201"}, `{"description":"created"}`},
+			},
+		},
+		{
+			name: "form parameters with a file become multipart",
+			spec: `{swagger: "2.0", paths: {/upload: {post: {parameters: [
+				{in: formData, name: file, type: file, required: true}, {in: formData, name: note, type: string}],
+				responses: {"204": {description: done}}}}}}`,
+			values: []convertedValue{
+				{[]string{"paths", "/upload", "post", "requestBody", "content", "multipart/form-data", "schema"},
+					`{"properties":{"file":{"format":"binary","type":"string"},"note":{"type":"string"}},"required":["file"],"type":"object"}`},
+			},
+		},
+		{
+			// Referenced parameters are inlined, since their location decides the override
+			name: "operation parameters override path parameters",
+			spec: `{swagger: "2.0", parameters: {Limit: {in: query, name: limit, type: integer}},
+				paths: {"/users/{id}": {parameters: [{in: path, name: id, required: true, type: string}, {in: query, name: q, type: string}],
+				get: {parameters: [{in: path, name: id, required: true, type: integer, format: int64}, {$ref: "#/parameters/Limit"}],
+				responses: {"200": {description: ok}}}}}}`,
+			values: []convertedValue{
+				{[]string{"components", "parameters", "Limit"}, `{"in":"query","name":"limit","schema":{"type":"integer"}}`},
+				{[]string{"paths", "/users/{id}", "get", "parameters"},
+					`[{"in":"path","name":"id","required":true,"schema":{"format":"int64","type":"integer"}},` +
+						`{"in":"query","name":"q","schema":{"type":"string"}},{"in":"query","name":"limit","schema":{"type":"integer"}}]`},
+			},
+		},
+		{
+			name: "shared responses are referenced",
+			spec: `{swagger: "2.0", responses: {NotFound: {description: missing, schema: {$ref: "#/definitions/Error"}}},
+				definitions: {Error: {type: object}},
+				paths: {/users: {get: {responses: {"404": {$ref: "#/responses/NotFound"}}}}}}`,
+			values: []convertedValue{
+				{[]string{"paths", "/users", "get", "responses", "404"}, `{"$ref":"#/components/responses/NotFound"}`},
+				{[]string{"components", "responses", "NotFound", "content", "application/json", "schema"}, `{"$ref":"#/components/schemas/Error"}`},
+			},
+		},
+		{
+			name: "security schemes",
+			spec: `{swagger: "2.0", paths: {}, securityDefinitions: {
+				basic: {type: basic},
+				key: {type: apiKey, in: header, name: X-Key},
+				oauth: {type: oauth2, flow: accessCode, authorizationUrl: "https://a", tokenUrl: "https://t", scopes: {read: r}}}}`,
+			values: []convertedValue{
+				{[]string{"components", "securitySchemes", "basic"}, `{"scheme":"basic","type":"http"}`},
+				{[]string{"components", "securitySchemes", "key"}, `{"in":"header","name":"X-Key","type":"apiKey"}`},
+				{[]string{"components", "securitySchemes", "oauth"},
+					`{"flows":{"authorizationCode":{"authorizationUrl":"https://a","scopes":{"read":"r"},"tokenUrl":"https://t"}},"type":"oauth2"}`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		var raw interface{}
+		if err := yaml.Unmarshal([]byte(tt.spec), &raw); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		s := &openAPISpec{doc: jsonCompatible(raw).(map[string]interface{})}
+		out, err := s.convertSwagger2()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		for _, v := range tt.values {
+			got, err := json.Marshal(valueAt(out, v.at...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != v.want {
+				t.Errorf("%s: %s is %s, want %s", tt.name, strings.Join(v.at, " > "), got, v.want)
+			}
+		}
+	}
+}
+
+func TestConvertSwagger2UnresolvedRef(t *testing.T) {
+	s := &openAPISpec{doc: map[string]interface{}{
+		"swagger": "2.0",
+		"paths": map[string]interface{}{
+			"/users": map[string]interface{}{"$ref": "#/x-missing"},
+		},
+	}}
+	if _, err := s.convertSwagger2(); err == nil || !strings.Contains(err.Error(), "/users") {
+		t.Errorf("got error %v, want one naming the path", err)
+	}
+}